@@ -0,0 +1,275 @@
+// protoc-gen-gomsg generates typed client and server code of services defined in .proto files
+// for the message protocol in pkg/message. For each service Foo it generates
+//
+//   - FooClient interface and NewFooClient, which sends requests through message.Invoker (message.Client)
+//   - FooServer interface to implement and RegisterFooServer, which registers it's methods to message.Server
+//
+// Files declaring messages with (msg_num) option register them to message type registry with message.RegisterFile
+// when the package is loaded, so a new .proto file needs no change of the registry.
+//
+// Requests of a service are dispatched by message type of the request,
+// so every request message must have (msg_num) option and be used by only one method.
+// Generation fails otherwise.
+//
+// Usage :
+//
+//	protoc --plugin=protoc-gen-gomsg=bin/protoc-gen-gomsg --gomsg_out=paths=source_relative:.. *.proto
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// messagePackage is import path of the message protocol runtime.
+const messagePackage = protogen.GoImportPath("git.garena.com/youngiek.song/entry_task/pkg/message")
+
+const contextPackage = protogen.GoImportPath("context")
+
+const protoPackage = protogen.GoImportPath("google.golang.org/protobuf/proto")
+
+// msgNumOption is full name of the message option declaring message type number.
+const msgNumOption = protoreflect.FullName("message.msg_num")
+
+func main() {
+	protogen.Options{}.Run(generate)
+}
+
+// generate checks request messages of services and generates code of files having services or numbered messages.
+func generate(gen *protogen.Plugin) error {
+	if err := checkRequests(gen); err != nil {
+		return err
+	}
+	msgNum, err := msgNumField(gen)
+	if err != nil {
+		return err
+	}
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		numbered := hasNumberedMessage(f, msgNum)
+		if len(f.Services) == 0 && !numbered {
+			continue
+		}
+		generateFile(gen, f, numbered)
+	}
+	return nil
+}
+
+// hasNumberedMessage reports whether file declares a message with (msg_num) option, msgNum is nil if the option is
+// not declared.
+func hasNumberedMessage(file *protogen.File, msgNum protoreflect.FieldDescriptor) bool {
+	if msgNum == nil {
+		return false
+	}
+	for _, msg := range file.Messages {
+		if hasOption(msg.Desc, msgNum) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRequests checks that request message of every method to generate has (msg_num) option and is used by no
+// other method, so that server dispatches each request to one method by it's message type.
+func checkRequests(gen *protogen.Plugin) error {
+	msgNum, err := msgNumField(gen)
+	if err != nil {
+		return err
+	}
+	methods := make(map[protoreflect.FullName]*protogen.Method)
+	for _, f := range gen.Files {
+		if !f.Generate {
+			continue
+		}
+		for _, service := range f.Services {
+			for _, method := range service.Methods {
+				input := method.Input.Desc
+				if other, ok := methods[input.FullName()]; ok {
+					return fmt.Errorf("request message %s is used by both %s and %s", input.FullName(), other.Desc.FullName(), method.Desc.FullName())
+				}
+				methods[input.FullName()] = method
+				if msgNum == nil || !hasOption(input, msgNum) {
+					return fmt.Errorf("request message %s of %s has no (msg_num) option", input.FullName(), method.Desc.FullName())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// msgNumField returns field of a message holding (msg_num) option, nil if the option is not declared in files
+// given to the plugin. The option is not linked to the plugin, so it is read from options by this field instead.
+func msgNumField(gen *protogen.Plugin) (protoreflect.FieldDescriptor, error) {
+	var number protoreflect.FieldNumber
+	for _, f := range gen.Files {
+		for _, ext := range f.Extensions {
+			if ext.Desc.FullName() == msgNumOption {
+				number = ext.Desc.Number()
+			}
+		}
+	}
+	if number == 0 {
+		return nil, nil
+	}
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("msg_num.proto"),
+		Syntax:  proto.String("proto2"), // presence of msg_num 0 is kept
+		Package: proto.String("gomsg"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("MsgNum"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:   proto.String("msg_num"),
+				Number: proto.Int32(int32(number)),
+				Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:   descriptorpb.FieldDescriptorProto_TYPE_UINT32.Enum(),
+			}},
+		}},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		return nil, err
+	}
+	return file.Messages().Get(0).Fields().Get(0), nil
+}
+
+// hasOption reports whether options of message have field set.
+func hasOption(msg protoreflect.MessageDescriptor, field protoreflect.FieldDescriptor) bool {
+	if msg.Options() == nil {
+		return false
+	}
+	b, err := proto.Marshal(msg.Options())
+	if err != nil {
+		return false
+	}
+	opts := dynamicpb.NewMessage(field.ContainingMessage())
+	if err = proto.Unmarshal(b, opts); err != nil {
+		return false
+	}
+	return opts.Has(field)
+}
+
+// generateFile generates a _msg.pb.go file containing client and server code of services in the file, and
+// registration of it's messages if numbered is set.
+func generateFile(gen *protogen.Plugin, file *protogen.File, numbered bool) {
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_msg.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-gomsg. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	if numbered {
+		// descriptor is built by init of the .pb.go file, which runs before this one
+		g.P("func init() {")
+		g.P(g.QualifiedGoIdent(messagePackage.Ident("RegisterFile")), "(", file.GoDescriptorIdent.GoName, ")")
+		g.P("}")
+		g.P()
+	}
+	for _, service := range file.Services {
+		generateClient(g, service)
+		generateServer(g, service)
+	}
+}
+
+// generateClient generates client interface of service and it's implementation.
+func generateClient(g *protogen.GeneratedFile, service *protogen.Service) {
+	clientName := service.GoName + "Client"
+	implName := lowerFirst(clientName)
+	g.P("// ", clientName, " is the client API for ", service.GoName, " service.")
+	g.P("type ", clientName, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, clientSignature(g, method))
+	}
+	g.P("}")
+	g.P()
+	g.P("type ", implName, " struct {")
+	g.P("cc ", g.QualifiedGoIdent(messagePackage.Ident("Invoker")))
+	g.P("}")
+	g.P()
+	g.P("// New", clientName, " create new ", clientName, " sending requests through cc.")
+	g.P("func New", clientName, "(cc ", g.QualifiedGoIdent(messagePackage.Ident("Invoker")), ") ", clientName, " {")
+	g.P("return &", implName, "{cc}")
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		g.P("func (c *", implName, ") ", clientSignature(g, method), " {")
+		g.P("res, err := c.cc.Invoke(ctx, req)")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("out, ok := res.(*", g.QualifiedGoIdent(method.Output.GoIdent), ")")
+		g.P("if !ok {")
+		g.P("return nil, ", g.QualifiedGoIdent(messagePackage.Ident("UnexpectedResponseError")), "{Response: res}")
+		g.P("}")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+func clientSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	return method.GoName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context")) + ", req *" + g.QualifiedGoIdent(method.Input.GoIdent) + ") (*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+}
+
+// generateServer generates server interface of service, service description and register function.
+func generateServer(g *protogen.GeneratedFile, service *protogen.Service) {
+	serverName := service.GoName + "Server"
+	descName := "_" + service.GoName + "_serviceDesc"
+	g.P("// ", serverName, " is the server API for ", service.GoName, " service.")
+	g.P("// Error returned by a method is sent to the client as error code of the response.")
+	g.P("type ", serverName, " interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, serverSignature(g, method))
+	}
+	g.P("}")
+	g.P()
+	g.P("// Register", serverName, " registers every method of srv to server s.")
+	g.P("func Register", serverName, "(s *", g.QualifiedGoIdent(messagePackage.Ident("Server")), ", srv ", serverName, ") {")
+	g.P("s.RegisterService(&", descName, ", srv)")
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		g.P("func ", handlerName(service, method), "(srv interface{}, ctx ", g.QualifiedGoIdent(contextPackage.Ident("Context")),
+			", req ", g.QualifiedGoIdent(protoPackage.Ident("Message")), ") (", g.QualifiedGoIdent(protoPackage.Ident("Message")), ", error) {")
+		g.P("return srv.(", serverName, ").", method.GoName, "(ctx, req.(*", g.QualifiedGoIdent(method.Input.GoIdent), "))")
+		g.P("}")
+		g.P()
+	}
+	g.P("var ", descName, " = ", g.QualifiedGoIdent(messagePackage.Ident("ServiceDesc")), "{")
+	g.P("ServiceName: ", `"`, service.Desc.FullName(), `",`)
+	g.P("HandlerType: (*", serverName, ")(nil),")
+	g.P("Methods: []", g.QualifiedGoIdent(messagePackage.Ident("MethodDesc")), "{")
+	for _, method := range service.Methods {
+		g.P("{")
+		g.P("MethodName: ", `"`, method.Desc.Name(), `",`)
+		g.P("Request: &", g.QualifiedGoIdent(method.Input.GoIdent), "{},")
+		g.P("Response: &", g.QualifiedGoIdent(method.Output.GoIdent), "{},")
+		g.P("Handler: ", handlerName(service, method), ",")
+		g.P("},")
+	}
+	g.P("},")
+	g.P("}")
+	g.P()
+}
+
+func serverSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	return method.GoName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context")) + ", req *" + g.QualifiedGoIdent(method.Input.GoIdent) + ") (*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+}
+
+func handlerName(service *protogen.Service, method *protogen.Method) string {
+	return "_" + service.GoName + "_" + method.GoName + "_Handler"
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return string(s[0]+'a'-'A') + s[1:]
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// optionsFile declares (msg_num) option like pkg/message/protocol/options.proto.
+var optionsFile = &descriptorpb.FileDescriptorProto{
+	Name:       proto.String("options.proto"),
+	Package:    proto.String("message"),
+	Syntax:     proto.String("proto3"),
+	Dependency: []string{"google/protobuf/descriptor.proto"},
+	Options:    &descriptorpb.FileOptions{GoPackage: proto.String("example.com/message")},
+	Extension: []*descriptorpb.FieldDescriptorProto{{
+		Name:     proto.String("msg_num"),
+		Number:   proto.Int32(50000),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_UINT32.Enum(),
+		Extendee: proto.String(".google.protobuf.MessageOptions"),
+	}},
+}
+
+// message returns message of name, with (msg_num) option of num if num is not negative.
+func message(name string, num int) *descriptorpb.DescriptorProto {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	if num >= 0 {
+		msg.Options = &descriptorpb.MessageOptions{}
+		// the option is not linked to the test, so it is set as unknown field like protoc sends it
+		b := make([]byte, 2*binary.MaxVarintLen64)
+		n := binary.PutUvarint(b, 50000<<3)
+		n += binary.PutUvarint(b[n:], uint64(num))
+		msg.Options.ProtoReflect().SetUnknown(b[:n])
+	}
+	return msg
+}
+
+// method returns method of name taking input message.
+func method(name, input string) *descriptorpb.MethodDescriptorProto {
+	return &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(name),
+		InputType:  proto.String(".message." + input),
+		OutputType: proto.String(".message.Response"),
+	}
+}
+
+// newPlugin returns plugin generating service of methods using messages, the file has no service without methods.
+func newPlugin(t *testing.T, messages []*descriptorpb.DescriptorProto, methods ...*descriptorpb.MethodDescriptorProto) *protogen.Plugin {
+	service := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("service.proto"),
+		Package:     proto.String("message"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  []string{"options.proto"},
+		Options:     &descriptorpb.FileOptions{GoPackage: proto.String("example.com/message")},
+		MessageType: append(messages, message("Response", -1)),
+	}
+	if len(methods) > 0 {
+		service.Service = []*descriptorpb.ServiceDescriptorProto{{Name: proto.String("UserService"), Method: methods}}
+	}
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"service.proto"},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			optionsFile,
+			service,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gen
+}
+
+func TestGenerate(t *testing.T) {
+	gen := newPlugin(t,
+		[]*descriptorpb.DescriptorProto{message("HealthcheckMessage", 0), message("LoginRequest", 1)},
+		method("Healthcheck", "HealthcheckMessage"), method("Login", "LoginRequest"))
+	if err := generate(gen); err != nil {
+		t.Fatal(err)
+	}
+	res := gen.Response()
+	if res.Error != nil || len(res.File) != 1 {
+		t.Fatalf("got %v", res)
+	}
+	content := res.File[0].GetContent()
+	for _, want := range []string{"func RegisterUserServiceServer", "message.RegisterFile(File_service_proto)"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("generated code has no %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestGenerateRegistersMessages(t *testing.T) {
+	// file without services is generated only to register it's numbered messages
+	gen := newPlugin(t, []*descriptorpb.DescriptorProto{message("Hello", 9)})
+	if err := generate(gen); err != nil {
+		t.Fatal(err)
+	}
+	res := gen.Response()
+	if len(res.File) != 1 || !strings.Contains(res.File[0].GetContent(), "message.RegisterFile(File_service_proto)") {
+		t.Errorf("got %v", res)
+	}
+
+	gen = newPlugin(t, nil)
+	if err := generate(gen); err != nil {
+		t.Fatal(err)
+	}
+	if res = gen.Response(); len(res.File) != 0 {
+		t.Errorf("file without services and numbered messages: got %v", res)
+	}
+}
+
+func TestGenerateRejectsRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []*descriptorpb.DescriptorProto
+		methods  []*descriptorpb.MethodDescriptorProto
+		err      string
+	}{
+		{
+			"request of two methods",
+			[]*descriptorpb.DescriptorProto{message("LoginRequest", 1)},
+			[]*descriptorpb.MethodDescriptorProto{method("Login", "LoginRequest"), method("Relogin", "LoginRequest")},
+			"used by both message.UserService.Login and message.UserService.Relogin",
+		},
+		{
+			"request without msg_num",
+			[]*descriptorpb.DescriptorProto{message("LoginRequest", 1), message("LogoutRequest", -1)},
+			[]*descriptorpb.MethodDescriptorProto{method("Login", "LoginRequest"), method("Logout", "LogoutRequest")},
+			"message.LogoutRequest of message.UserService.Logout has no (msg_num) option",
+		},
+	}
+	for _, test := range tests {
+		err := generate(newPlugin(t, test.messages, test.methods...))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want error containing %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"git.garena.com/youngiek.song/entry_task/internal/logger"
+	"go.uber.org/zap"
+)
+
+// panicCounter counts panics recovered from handlers of each method, see message.ServerConfig.OnPanic.
+type panicCounter struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+}
+
+func newPanicCounter() *panicCounter {
+	return &panicCounter{counts: make(map[string]int64)}
+}
+
+func (c *panicCounter) add(method string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[method]++
+}
+
+// ServeHTTP writes counts by method as JSON object.
+func (c *panicCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	b, err := json.Marshal(c.counts)
+	c.mutex.Unlock()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// serveAdmin serves counters of the server on addr, which should be reachable only by operators.
+// It has it's own mux, so nothing registered on http.DefaultServeMux is exposed.
+func serveAdmin(addr string, panics *panicCounter) {
+	mux := http.NewServeMux()
+	mux.Handle("/panics", panics)
+	logger.Instance.Info("Admin server listening on " + addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Instance.Error("Admin server stopped", zap.String("error", err.Error()))
+	}
+}
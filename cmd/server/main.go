@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"git.garena.com/youngiek.song/entry_task/internal/cache"
 	"git.garena.com/youngiek.song/entry_task/internal/jwt"
 	"git.garena.com/youngiek.song/entry_task/internal/logger"
+	"git.garena.com/youngiek.song/entry_task/internal/models"
+	"git.garena.com/youngiek.song/entry_task/internal/notifier"
+	"git.garena.com/youngiek.song/entry_task/internal/session"
 	"git.garena.com/youngiek.song/entry_task/pkg/message"
+	"github.com/go-redis/redis/v7"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/sevlyar/go-daemon"
 	"go.uber.org/zap"
@@ -26,24 +34,67 @@ func initCmdLineFlag() {
 
 type config struct {
 	Tcp struct {
-		Host string `yaml:"host"`
-		Port string `yaml:"port"`
+		Host            string `yaml:"host"`
+		Port            string `yaml:"port"`
+		IdleTimeout     int64  `yaml:"idle_timeout"`
+		ReadTimeout     int64  `yaml:"read_timeout"`
+		WriteTimeout    int64  `yaml:"write_timeout"`
+		MaxFrameSize    int    `yaml:"max_frame_size"`
+		ShutdownTimeout int64  `yaml:"shutdown_timeout"`
+		TLS             struct {
+			Enabled bool   `yaml:"enabled"`
+			Cert    string `yaml:"cert"`
+			Key     string `yaml:"key"`
+			CA      string `yaml:"ca"`
+		} `yaml:"tls"`
+		RateLimit struct {
+			RPS   float64 `yaml:"rps"`
+			Burst int     `yaml:"burst"`
+		} `yaml:"rate_limit"`
 	} `yaml:"tcp"`
 	Database struct {
+		Driver   string `yaml:"driver"`
 		User     string `yaml:"user"`
 		Password string `yaml:"password"`
 		Host     string `yaml:"host"`
 		Port     string `yaml:"port"`
 		MaxConn  int    `yaml:"max_connection"`
 	} `yaml:"database"`
+	Password struct {
+		BcryptCost      int    `yaml:"bcrypt_cost"`
+		ResetExpire     int64  `yaml:"reset_expire"`
+		ResetNotifyFile string `yaml:"reset_notify_file"`
+	} `yaml:"password"`
 	JWT struct {
-		SecretKey  string `yaml:"secret"`
-		ExpireTime int64  `yaml:"expire"`
+		SecretKey         string `yaml:"secret"`
+		KeyFile           string `yaml:"key_file"`
+		ExpireTime        int64  `yaml:"expire"`
+		RefreshExpireTime int64  `yaml:"refresh_expire"`
+		Issuer            string `yaml:"issuer"`
+		Audience          string `yaml:"audience"`
+		Leeway            int64  `yaml:"leeway"`
+		Revocation        struct {
+			Redis struct {
+				Host string `yaml:"host"`
+				Port string `yaml:"port"`
+			} `yaml:"redis"`
+		} `yaml:"revocation"`
 	} `yaml:"jwt"`
+	Session struct {
+		Strategy   string `yaml:"strategy"`
+		IdleExpire int64  `yaml:"idle_expire"`
+		Redis      struct {
+			Host string `yaml:"host"`
+			Port string `yaml:"port"`
+		} `yaml:"redis"`
+	} `yaml:"session"`
 	Log struct {
 		Level string `yaml:"level"`
 		Path  string `yaml:"path"`
 	} `yaml:"log"`
+	Admin struct {
+		Addr string `yaml:"addr"`
+	} `yaml:"admin"`
 }
 
 func getConfig(path string) (*config, error) {
@@ -95,13 +146,123 @@ func main() {
 	initCmdLineFlag()
 	conf, err := getConfig(configPath)
 	if err != nil {
-		fmt.Println("Cannot open config file ", err.Error)
+		fmt.Println("Cannot open config file ", err.Error())
 		return
 	}
 	logger.Init(conf.Log.Path, conf.Log.Level)
-	// initialize database connection
-	db := initDB(conf.Database.Host, conf.Database.Port, conf.Database.User, conf.Database.Password, conf.Database.MaxConn)
-	tokenIssuer := jwt.NewTokenIssuer(conf.JWT.SecretKey, time.Minute*time.Duration(conf.JWT.ExpireTime))
-	server := message.NewServer(conf.Tcp.Host, conf.Tcp.Port, db, tokenIssuer, logger.Instance)
-	server.Run()
+	// initialize user store, in memory store loses all users on exit
+	var store models.UserStore
+	hasher := models.NewBcryptHasher(conf.Password.BcryptCost)
+	var db *sql.DB
+	if conf.Database.Driver == "memory" {
+		logger.Instance.Warn("Using in-memory user store")
+		store = models.NewMemoryStore(hasher)
+	} else {
+		db = initDB(conf.Database.Host, conf.Database.Port, conf.Database.User, conf.Database.Password, conf.Database.MaxConn)
+		mysqlStore, err := models.NewMySQLStore(db, hasher)
+		if err != nil {
+			logger.Instance.Fatal("Cannot prepare DB statements", zap.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer mysqlStore.Close()
+		store = mysqlStore
+	}
+	// signing keys are rotated by editing the key file, which is reloaded without restart
+	// stores on the same Redis share one client and it's pool of connections
+	redisClients := make(map[string]*redis.Client)
+	redisClient := func(host, port string) *redis.Client {
+		addr := host + ":" + port
+		if redisClients[addr] == nil {
+			redisClients[addr] = cache.NewClient(host, port)
+		}
+		return redisClients[addr]
+	}
+	var tokenIssuer *jwt.TokenIssuer
+	if conf.JWT.KeyFile != "" {
+		keys, err := jwt.LoadKeyFile(conf.JWT.KeyFile)
+		if err != nil {
+			logger.Instance.Fatal("Cannot load JWT key file", zap.String("error", err.Error()))
+			os.Exit(1)
+		}
+		tokenIssuer = jwt.NewKeySetTokenIssuer(keys, time.Minute*time.Duration(conf.JWT.ExpireTime))
+	} else {
+		tokenIssuer = jwt.NewTokenIssuer(conf.JWT.SecretKey, time.Minute*time.Duration(conf.JWT.ExpireTime))
+	}
+	tokenIssuer.SetIssuer(conf.JWT.Issuer, conf.JWT.Audience)
+	tokenIssuer.SetLeeway(time.Second * time.Duration(conf.JWT.Leeway))
+	// revoked tokens are shared by backend servers on Redis, or kept in memory of this server
+	if addr := conf.JWT.Revocation.Redis; addr.Host != "" {
+		tokenIssuer.SetRevocationList(cache.NewRevocationList(redisClient(addr.Host, addr.Port)))
+	}
+	serverConfig := message.ServerConfig{
+		Stream: message.StreamConfig{
+			IdleTimeout:  time.Second * time.Duration(conf.Tcp.IdleTimeout),
+			ReadTimeout:  time.Second * time.Duration(conf.Tcp.ReadTimeout),
+			WriteTimeout: time.Second * time.Duration(conf.Tcp.WriteTimeout),
+			MaxFrameSize: conf.Tcp.MaxFrameSize,
+		},
+	}
+	serverConfig.ResetTokenExpire = time.Minute * time.Duration(conf.Password.ResetExpire)
+	serverConfig.RefreshTokenExpire = time.Minute * time.Duration(conf.JWT.RefreshExpireTime)
+	serverConfig.Notifier = notifier.NewFileNotifier(conf.Password.ResetNotifyFile)
+	// access tokens are JWT of tokenIssuer, or ids of sessions kept on server which are revoked instantly
+	idleExpire := time.Minute * time.Duration(conf.Session.IdleExpire)
+	switch conf.Session.Strategy {
+	case "", "jwt":
+		// sessions are recorded to be listed to users, on Redis to list ones of every backend server
+		if addr := conf.Session.Redis; addr.Host != "" {
+			records := cache.NewSessionRecords(redisClient(addr.Host, addr.Port), serverConfig.RefreshTokenExpire)
+			serverConfig.Sessions = session.NewJWTManager(tokenIssuer, records)
+		}
+	case "redis":
+		if conf.Session.Redis.Host == "" {
+			logger.Instance.Fatal("Session strategy redis requires session.redis.host")
+			os.Exit(1)
+		}
+		client := redisClient(conf.Session.Redis.Host, conf.Session.Redis.Port)
+		serverConfig.Sessions = cache.NewSessionStore(client, idleExpire)
+	case "memory":
+		logger.Instance.Warn("Using in-memory session store")
+		serverConfig.Sessions = session.NewMemoryStore(idleExpire)
+	default:
+		logger.Instance.Fatal("Unknown session strategy", zap.String("strategy", conf.Session.Strategy))
+		os.Exit(1)
+	}
+	// tokens sent to users are kept on Redis shared by backend servers, or in memory of this server
+	if addr := conf.Session.Redis; addr.Host != "" {
+		client := redisClient(addr.Host, addr.Port)
+		serverConfig.ResetTokens = cache.NewResetTokens(client, serverConfig.ResetTokenExpire)
+		serverConfig.RefreshTokens = cache.NewRefreshTokens(client, serverConfig.RefreshTokenExpire, session.RefreshGrace)
+	} else {
+		logger.Instance.Warn("Using in-memory password reset and refresh tokens")
+	}
+	if conf.Tcp.TLS.Enabled {
+		serverConfig.TLS = &message.TLSConfig{
+			CertFile: conf.Tcp.TLS.Cert,
+			KeyFile:  conf.Tcp.TLS.Key,
+			CAFile:   conf.Tcp.TLS.CA,
+		}
+	}
+	serverConfig.RateLimit = message.RateLimitConfig{Rate: conf.Tcp.RateLimit.RPS, Burst: conf.Tcp.RateLimit.Burst}
+	if conf.Admin.Addr != "" {
+		panics := newPanicCounter()
+		serverConfig.OnPanic = panics.add
+		go serveAdmin(conf.Admin.Addr, panics)
+	}
+	server := message.NewServer(conf.Tcp.Host, conf.Tcp.Port, serverConfig, store, tokenIssuer, logger.Instance)
+	go server.Run()
+
+	// drain connections on SIGINT/SIGTERM so that requests in progress are not dropped
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	logger.Instance.Info("Shutting down", zap.String("signal", (<-sig).String()))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(conf.Tcp.ShutdownTimeout))
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		logger.Instance.Error("Error shutting down", zap.String("error", err.Error()))
+	}
+	if db != nil {
+		db.Close()
+	}
 }
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"git.garena.com/youngiek.song/entry_task/internal/cache"
 	"git.garena.com/youngiek.song/entry_task/internal/controller"
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
 	"git.garena.com/youngiek.song/entry_task/internal/logger"
 	"git.garena.com/youngiek.song/entry_task/pkg/message"
 	"gopkg.in/yaml.v2"
@@ -32,14 +35,39 @@ type config struct {
 		DocRoot string `yaml:"document_root"`
 	} `yaml:"http"`
 	TCP struct {
-		Host    string `yaml:"host"`
-		Port    string `yaml:"port"`
-		MaxConn int    `yaml:"max_connection"`
+		Host         string `yaml:"host"`
+		Port         string `yaml:"port"`
+		MaxConn      int32  `yaml:"max_connection"`
+		StreamCalls  int32  `yaml:"max_stream_calls"`
+		Timeout      int64  `yaml:"request_timeout"`
+		MaxIdle      int64  `yaml:"max_idle"`
+		ReadTimeout  int64  `yaml:"read_timeout"`
+		WriteTimeout int64  `yaml:"write_timeout"`
+		MaxFrameSize int    `yaml:"max_frame_size"`
+		TLS          struct {
+			Enabled    bool   `yaml:"enabled"`
+			Cert       string `yaml:"cert"`
+			Key        string `yaml:"key"`
+			CA         string `yaml:"ca"`
+			ServerName string `yaml:"server_name"`
+		} `yaml:"tls"`
 	} `yaml:"tcp"`
 	Redis struct {
 		Host string `yaml:"host"`
 		Port string `yaml:"port"`
 	} `yaml:"redis"`
+	JWT struct {
+		KeyRefresh int64  `yaml:"key_refresh"`
+		Issuer     string `yaml:"issuer"`
+		Audience   string `yaml:"audience"`
+		Leeway     int64  `yaml:"leeway"`
+		Revocation struct {
+			Redis struct {
+				Host string `yaml:"host"`
+				Port string `yaml:"port"`
+			} `yaml:"redis"`
+		} `yaml:"revocation"`
+	} `yaml:"jwt"`
 	Log struct {
 		Level string `yaml:"level"`
 		Path  string `yaml:"path"`
@@ -60,8 +88,17 @@ func getConfig(path string) (*config, error) {
 func initRoute(userController *controller.UserController, docRoot string) {
 	r := mux.NewRouter()
 	r.HandleFunc("/login", userController.Login)
+	r.HandleFunc("/logout", userController.Logout).Methods("POST")
+	r.HandleFunc("/sessions/logout", userController.RevokeSession).Methods("POST")
+	r.HandleFunc("/signup", userController.SignupPage).Methods("GET")
+	r.HandleFunc("/signup", userController.Signup).Methods("POST")
 	r.HandleFunc("/main", userController.Main)
 	r.HandleFunc("/users/{id}", userController.EditUserInfo)
+	r.HandleFunc("/users/{id}/password", userController.ChangePassword).Methods("POST")
+	r.HandleFunc("/password/reset", userController.PasswordResetPage).Methods("GET")
+	r.HandleFunc("/password/reset", userController.RequestPasswordReset).Methods("POST")
+	r.HandleFunc("/password/reset/confirm", userController.ResetPasswordPage).Methods("GET")
+	r.HandleFunc("/password/reset/confirm", userController.ResetPassword).Methods("POST")
 	r.HandleFunc("/users/{id}/profile/picture", userController.UploadPhoto)
 	r.HandleFunc("/", userController.LoginPage)
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(docRoot+"/static/"))))
@@ -87,13 +124,53 @@ func main() {
 	initCmdLineFlag()
 	cfg, err := getConfig(configPath)
 	if err != nil {
-		fmt.Println("Cannot open config file ", err.Error)
+		fmt.Println("Cannot open config file ", err.Error())
 		return
 	}
 	logger.Init(cfg.Log.Path, cfg.Log.Level)
-	client := message.NewClient(cfg.TCP.Host, cfg.TCP.Port, cfg.TCP.MaxConn)
-	cache := cache.NewUserCache(cfg.Redis.Host, cfg.Redis.Port)
-	userController := controller.NewUserController(client, cache, logger.Instance, cfg.HTTP.DocRoot)
+	poolConfig := message.PoolConfig{
+		MaxConn:  cfg.TCP.MaxConn,
+		MaxCalls: cfg.TCP.StreamCalls,
+		MaxIdle:  time.Second * time.Duration(cfg.TCP.MaxIdle),
+		Stream: message.StreamConfig{
+			ReadTimeout:  time.Second * time.Duration(cfg.TCP.ReadTimeout),
+			WriteTimeout: time.Second * time.Duration(cfg.TCP.WriteTimeout),
+			MaxFrameSize: cfg.TCP.MaxFrameSize,
+		},
+		Logger: logger.Instance,
+	}
+	if cfg.TCP.TLS.Enabled {
+		poolConfig.TLS = &message.TLSConfig{
+			CertFile:   cfg.TCP.TLS.Cert,
+			KeyFile:    cfg.TCP.TLS.Key,
+			CAFile:     cfg.TCP.TLS.CA,
+			ServerName: cfg.TCP.TLS.ServerName,
+		}
+		err = poolConfig.TLS.Check()
+		if err != nil {
+			logger.Instance.Fatal("Error loading TLS certificates", zap.String("error", err.Error()))
+		}
+	}
+	client := message.NewClient(cfg.TCP.Host, cfg.TCP.Port, poolConfig)
+	// access tokens are verified with public keys of backend, fetched from it
+	timeout := time.Second * time.Duration(cfg.TCP.Timeout)
+	keys := jwt.NewRemoteKeySet(func() ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		jwks, err := client.PublicKeys(ctx)
+		if err != nil {
+			logger.Instance.Warn("Cannot fetch public keys of backend", zap.String("error", err.Error()))
+		}
+		return jwks, err
+	}, time.Second*time.Duration(cfg.JWT.KeyRefresh))
+	verifier := jwt.NewTokenVerifier(keys)
+	verifier.ExpectClaims(cfg.JWT.Issuer, cfg.JWT.Audience)
+	verifier.SetLeeway(time.Second * time.Duration(cfg.JWT.Leeway))
+	if redis := cfg.JWT.Revocation.Redis; redis.Host != "" {
+		verifier.SetRevocationList(cache.NewRevocationList(cache.NewClient(redis.Host, redis.Port)))
+	}
+	userCache := cache.NewUserCache(cfg.Redis.Host, cfg.Redis.Port)
+	userController := controller.NewUserController(client, userCache, verifier, logger.Instance, cfg.HTTP.DocRoot, timeout)
 	initRoute(userController, cfg.HTTP.DocRoot)
 
 	logger.Instance.Info("Web Server has started, Listening on port " + cfg.HTTP.Port + "...")
@@ -3,6 +3,7 @@ module git.garena.com/youngiek.song/entry_task
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/go-sql-driver/mysql v1.5.0
@@ -12,6 +13,7 @@ require (
 	github.com/kardianos/osext v0.0.0-20190222173326-2bc1f35cddc0 // indirect
 	github.com/sevlyar/go-daemon v0.1.5
 	go.uber.org/zap v1.14.1
+	golang.org/x/crypto v0.0.0-20190510104115-cbcb75029529
 	google.golang.org/protobuf v1.20.1
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/yaml.v2 v2.2.4
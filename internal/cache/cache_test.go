@@ -0,0 +1,22 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v7"
+)
+
+// newTestClient starts Redis in memory and returns client of it with the server, to move it's clock.
+func newTestClient(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
@@ -0,0 +1,13 @@
+package cache
+
+import "github.com/go-redis/redis/v7"
+
+// NewClient create client of Redis of host and port. Client keeps a pool of connections, so one client is shared by
+// the stores on the same Redis.
+func NewClient(host, port string) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     host + ":" + port,
+		Password: "",
+		DB:       0,
+	})
+}
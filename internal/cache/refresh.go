@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+	"github.com/go-redis/redis/v7"
+)
+
+// RefreshTokens is session.RefreshTokens on Redis shared by backend servers.
+// Token is stored as a hash under it's hash, holding the user id, the family and when it was used, and expires with
+// the token. Hashes of tokens of each family and families of each user are kept in sets to revoke them.
+type RefreshTokens struct {
+	client *redis.Client
+	expire time.Duration
+	grace  time.Duration
+}
+
+// NewRefreshTokens create refresh tokens on Redis of client, tokens expire after expire, 7 days if 0, and
+// used ones are accepted again within grace, see session.RefreshGrace.
+func NewRefreshTokens(client *redis.Client, expire, grace time.Duration) *RefreshTokens {
+	if expire <= 0 {
+		expire = 7 * 24 * time.Hour
+	}
+	return &RefreshTokens{
+		client: client,
+		expire: expire,
+		grace:  grace,
+	}
+}
+
+var _ session.RefreshTokens = (*RefreshTokens)(nil)
+
+// useRefreshToken marks token of KEYS[1] used at ARGV[1] unless it was used before, returning the user id, the
+// family and the time it was first used, 0 if it was not.
+var useRefreshToken = redis.NewScript(`
+local t = redis.call("HMGET", KEYS[1], "user", "family", "used")
+if not t[1] then
+	return false
+end
+if t[3] == "0" then
+	redis.call("HSET", KEYS[1], "used", ARGV[1])
+end
+return t
+`)
+
+// revokeRefreshFamily removes family set of KEYS[1] with tokens of it, whose keys are ARGV[1] followed by the hash,
+// and removes family ARGV[2] from the user's set of KEYS[2].
+var revokeRefreshFamily = redis.NewScript(`
+for _, hash in ipairs(redis.call("SMEMBERS", KEYS[1])) do
+	redis.call("DEL", ARGV[1] .. hash)
+end
+redis.call("DEL", KEYS[1])
+redis.call("SREM", KEYS[2], ARGV[2])
+return 1
+`)
+
+const refreshTokenPrefix = "refresh:token:"
+
+func refreshTokenKey(hash string) string {
+	return refreshTokenPrefix + hash
+}
+
+func refreshFamilyKey(family string) string {
+	return "refresh:family:" + family
+}
+
+func userRefreshFamiliesKey(id string) string {
+	return "refresh:user:" + id
+}
+
+func (r *RefreshTokens) Issue(ctx context.Context, id, family string) (string, error) {
+	return r.add(r.client.WithContext(ctx), id, family)
+}
+
+// add issues new token of family of user id, extending the family and the user's families with it.
+func (r *RefreshTokens) add(client *redis.Client, id, family string) (string, error) {
+	token, err := session.NewID()
+	if err != nil {
+		return "", err
+	}
+	hash := session.HashID(token)
+	_, err = client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(refreshTokenKey(hash), "user", id, "family", family, "used", 0)
+		pipe.Expire(refreshTokenKey(hash), r.expire)
+		pipe.SAdd(refreshFamilyKey(family), hash)
+		pipe.Expire(refreshFamilyKey(family), r.expire)
+		pipe.SAdd(userRefreshFamiliesKey(id), family)
+		pipe.Expire(userRefreshFamiliesKey(id), r.expire)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (r *RefreshTokens) Rotate(ctx context.Context, token string) (string, string, string, error) {
+	client := r.client.WithContext(ctx)
+	now := time.Now()
+	v, err := useRefreshToken.Run(client, []string{refreshTokenKey(session.HashID(token))}, now.UnixNano()).Result()
+	if err == redis.Nil {
+		return "", "", "", session.ErrRefreshInvalid
+	}
+	if err != nil {
+		return "", "", "", err
+	}
+	fields := v.([]interface{})
+	id, _ := fields[0].(string)
+	family, _ := fields[1].(string)
+	used, _ := fields[2].(string)
+	if used != "0" && now.Sub(unixNano(used)) > r.grace {
+		if err = r.revokeFamily(client, id, family); err != nil {
+			return "", "", "", err
+		}
+		return id, family, "", session.ErrRefreshReused
+	}
+	next, err := r.add(client, id, family)
+	return id, family, next, err
+}
+
+func (r *RefreshTokens) Revoke(ctx context.Context, token string) error {
+	client := r.client.WithContext(ctx)
+	fields, err := client.HMGet(refreshTokenKey(session.HashID(token)), "user", "family").Result()
+	if err != nil {
+		return err
+	}
+	id, ok := fields[0].(string)
+	if !ok {
+		return nil
+	}
+	family, _ := fields[1].(string)
+	return r.revokeFamily(client, id, family)
+}
+
+func (r *RefreshTokens) RevokeSession(ctx context.Context, id, family string) error {
+	client := r.client.WithContext(ctx)
+	// family of other user is not in the user's set
+	ok, err := client.SIsMember(userRefreshFamiliesKey(id), family).Result()
+	if err != nil || !ok {
+		return err
+	}
+	return r.revokeFamily(client, id, family)
+}
+
+func (r *RefreshTokens) RevokeUser(ctx context.Context, id string) error {
+	client := r.client.WithContext(ctx)
+	families, err := client.SMembers(userRefreshFamiliesKey(id)).Result()
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		if err = r.revokeFamily(client, id, family); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RefreshTokens) revokeFamily(client *redis.Client, id, family string) error {
+	keys := []string{refreshFamilyKey(family), userRefreshFamiliesKey(id)}
+	return revokeRefreshFamily.Run(client, keys, refreshTokenPrefix, family).Err()
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+)
+
+func TestRefreshTokens(t *testing.T) {
+	client, server := newTestClient(t)
+	r := NewRefreshTokens(client, time.Hour, time.Hour)
+	ctx := context.Background()
+	token, err := r.Issue(ctx, "song", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, family, next, err := r.Rotate(ctx, token)
+	if id != "song" || family != "session" || next == "" || err != nil {
+		t.Fatalf("got %q, %q, %q, %v", id, family, next, err)
+	}
+	// used token is accepted again within grace time
+	if _, _, other, err := r.Rotate(ctx, token); other == "" || other == next || err != nil {
+		t.Errorf("used token within grace: got %q, %v", other, err)
+	}
+	if _, _, _, err := r.Rotate(ctx, "unknown"); err != session.ErrRefreshInvalid {
+		t.Errorf("unknown token: got %v, want ErrRefreshInvalid", err)
+	}
+
+	// after grace time the family is revoked, including the token rotated from it
+	r = NewRefreshTokens(client, time.Hour, 0)
+	token, _ = r.Issue(ctx, "song", "reused")
+	_, _, next, _ = r.Rotate(ctx, token)
+	time.Sleep(time.Millisecond)
+	if id, family, _, err := r.Rotate(ctx, token); id != "song" || family != "reused" || err != session.ErrRefreshReused {
+		t.Errorf("reused token: got %q, %q, %v, want ErrRefreshReused", id, family, err)
+	}
+	if _, _, _, err := r.Rotate(ctx, next); err != session.ErrRefreshInvalid {
+		t.Errorf("token of revoked family: got %v, want ErrRefreshInvalid", err)
+	}
+
+	token, _ = r.Issue(ctx, "song", "expired")
+	server.FastForward(2 * time.Hour)
+	if _, _, _, err := r.Rotate(ctx, token); err != session.ErrRefreshInvalid {
+		t.Errorf("expired token: got %v, want ErrRefreshInvalid", err)
+	}
+}
+
+func TestRefreshTokensRevoke(t *testing.T) {
+	client, _ := newTestClient(t)
+	r := NewRefreshTokens(client, time.Hour, time.Hour)
+	ctx := context.Background()
+	phone, _ := r.Issue(ctx, "song", "phone")
+	laptop, _ := r.Issue(ctx, "song", "laptop")
+	tablet, _ := r.Issue(ctx, "song", "tablet")
+	other, _ := r.Issue(ctx, "kim", "other")
+
+	if err := r.Revoke(ctx, phone); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := r.Rotate(ctx, phone); err != session.ErrRefreshInvalid {
+		t.Errorf("revoked token: got %v, want ErrRefreshInvalid", err)
+	}
+	// family of other user is not revoked
+	if err := r.RevokeSession(ctx, "kim", "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, laptop, err := r.Rotate(ctx, laptop); laptop == "" || err != nil {
+		t.Errorf("session revoked by other user: %v", err)
+	}
+	if err := r.RevokeSession(ctx, "song", "laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := r.Rotate(ctx, laptop); err != session.ErrRefreshInvalid {
+		t.Errorf("token of revoked session: got %v, want ErrRefreshInvalid", err)
+	}
+	if err := r.RevokeUser(ctx, "song"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := r.Rotate(ctx, tablet); err != session.ErrRefreshInvalid {
+		t.Errorf("token of revoked user: got %v, want ErrRefreshInvalid", err)
+	}
+	if _, _, next, err := r.Rotate(ctx, other); next == "" || err != nil {
+		t.Errorf("token of other user: %v", err)
+	}
+}
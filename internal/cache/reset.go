@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+	"github.com/go-redis/redis/v7"
+)
+
+// ResetTokens is session.ResetTokens on Redis shared by backend servers, so that token sent to the user works on
+// any server and after restart. Token is stored under it's hash with the user id as value, and expires with it.
+// Hashes of tokens of each user are kept in a set to revoke them.
+type ResetTokens struct {
+	client *redis.Client
+	expire time.Duration
+}
+
+// NewResetTokens create reset tokens on Redis of client, tokens expire after expire, 15 minutes if 0.
+func NewResetTokens(client *redis.Client, expire time.Duration) *ResetTokens {
+	if expire <= 0 {
+		expire = 15 * time.Minute
+	}
+	return &ResetTokens{
+		client: client,
+		expire: expire,
+	}
+}
+
+var _ session.ResetTokens = (*ResetTokens)(nil)
+
+func resetTokenKey(hash string) string {
+	return "reset:" + hash
+}
+
+func userResetTokensKey(id string) string {
+	return "reset:user:" + id
+}
+
+func (r *ResetTokens) Issue(ctx context.Context, id string) (string, time.Time, error) {
+	token, err := session.NewID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(r.expire)
+	hash := session.HashID(token)
+	_, err = r.client.WithContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(resetTokenKey(hash), id, r.expire)
+		// set lives as long as the last token of the user
+		pipe.SAdd(userResetTokensKey(id), hash)
+		pipe.Expire(userResetTokensKey(id), r.expire)
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+func (r *ResetTokens) Use(ctx context.Context, token string) (string, error) {
+	client := r.client.WithContext(ctx)
+	hash := session.HashID(token)
+	key := resetTokenKey(hash)
+	var get *redis.StringCmd
+	// token is read and deleted at once, so that it is used only once by concurrent requests
+	_, err := client.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(key)
+		pipe.Del(key)
+		return nil
+	})
+	if err == redis.Nil {
+		return "", session.ErrResetTokenInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	// hash left in the set is harmless, it expires with the set
+	client.SRem(userResetTokensKey(get.Val()), hash)
+	return get.Val(), nil
+}
+
+func (r *ResetTokens) RevokeUser(ctx context.Context, id string) error {
+	client := r.client.WithContext(ctx)
+	hashes, err := client.SMembers(userResetTokensKey(id)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{userResetTokensKey(id)}
+	for _, hash := range hashes {
+		keys = append(keys, resetTokenKey(hash))
+	}
+	return client.Del(keys...).Err()
+}
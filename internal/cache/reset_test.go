@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+)
+
+func TestResetTokens(t *testing.T) {
+	client, server := newTestClient(t)
+	r := NewResetTokens(client, time.Hour)
+	ctx := context.Background()
+	token, _, err := r.Issue(ctx, "song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := r.Use(ctx, token); id != "song" || err != nil {
+		t.Errorf("got %q, %v", id, err)
+	}
+	if _, err := r.Use(ctx, token); err != session.ErrResetTokenInvalid {
+		t.Errorf("used token: got %v, want ErrResetTokenInvalid", err)
+	}
+
+	// revoking the user revokes only it's tokens
+	token, _, _ = r.Issue(ctx, "song")
+	other, _, _ := r.Issue(ctx, "kim")
+	if err := r.RevokeUser(ctx, "song"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Use(ctx, token); err != session.ErrResetTokenInvalid {
+		t.Errorf("revoked token: got %v, want ErrResetTokenInvalid", err)
+	}
+	if id, err := r.Use(ctx, other); id != "kim" || err != nil {
+		t.Errorf("token of other user: got %q, %v", id, err)
+	}
+
+	token, _, _ = r.Issue(ctx, "song")
+	server.FastForward(2 * time.Hour)
+	if _, err := r.Use(ctx, token); err != session.ErrResetTokenInvalid {
+		t.Errorf("expired token: got %v, want ErrResetTokenInvalid", err)
+	}
+}
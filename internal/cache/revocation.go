@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"strconv"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
+	"github.com/go-redis/redis/v7"
+)
+
+// RevocationList is jwt.RevocationList on Redis shared by backend servers.
+// Revocations are stored with expiration of the tokens, so Redis removes them once tokens expire.
+type RevocationList struct {
+	client *redis.Client
+}
+
+// NewRevocationList create revocation list on Redis of client.
+func NewRevocationList(client *redis.Client) *RevocationList {
+	return &RevocationList{client: client}
+}
+
+var _ jwt.RevocationList = (*RevocationList)(nil)
+
+func (l *RevocationList) RevokeToken(jti string, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return nil // expired token is rejected anyway, and zero ttl would keep the key forever
+	}
+	return l.client.Set("revoked:token:"+jti, 1, ttl).Err()
+}
+
+func (l *RevocationList) TokenRevoked(jti string) (bool, error) {
+	n, err := l.client.Exists("revoked:token:" + jti).Result()
+	return n > 0, err
+}
+
+func (l *RevocationList) RevokeUser(id string, at, expires time.Time) error {
+	ttl := time.Until(expires)
+	if ttl <= 0 {
+		return nil
+	}
+	return l.client.Set("revoked:user:"+id, at.UnixNano(), ttl).Err()
+}
+
+func (l *RevocationList) UserRevokedAt(id string) (time.Time, error) {
+	v, err := l.client.Get("revoked:user:" + id).Result()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	nsec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, nsec), nil
+}
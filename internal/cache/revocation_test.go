@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevocationList(t *testing.T) {
+	client, server := newTestClient(t)
+	l := NewRevocationList(client)
+	if err := l.RevokeToken("jti", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := l.TokenRevoked("jti"); !revoked || err != nil {
+		t.Errorf("revoked token: got %v, %v", revoked, err)
+	}
+	if revoked, err := l.TokenRevoked("other"); revoked || err != nil {
+		t.Errorf("other token: got %v, %v", revoked, err)
+	}
+	// expired token is not stored
+	l.RevokeToken("expired", time.Now().Add(-time.Second))
+	if revoked, _ := l.TokenRevoked("expired"); revoked {
+		t.Error("expired token is stored")
+	}
+
+	at := time.Now()
+	if err := l.RevokeUser("song", at, at.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := l.UserRevokedAt("song"); !got.Equal(at) || err != nil {
+		t.Errorf("revoked user: got %v, %v, want %v", got, err, at)
+	}
+	if got, err := l.UserRevokedAt("kim"); !got.IsZero() || err != nil {
+		t.Errorf("other user: got %v, %v", got, err)
+	}
+
+	// revocations are removed once tokens expire
+	server.FastForward(2 * time.Hour)
+	if revoked, _ := l.TokenRevoked("jti"); revoked {
+		t.Error("revocation of expired token is kept")
+	}
+	if got, _ := l.UserRevokedAt("song"); !got.IsZero() {
+		t.Errorf("revocation of user is kept: got %v", got)
+	}
+}
@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+	"github.com/go-redis/redis/v7"
+)
+
+// SessionRecords is session.Records on Redis shared by backend servers.
+// Session is stored as a hash under it's id holding the user id and the device, and expires when not seen for
+// expire, which is extended on every Touch. Ids of sessions of each user are kept in a set to list and remove them.
+type SessionRecords struct {
+	client *redis.Client
+	expire time.Duration
+}
+
+// NewSessionRecords create session records on Redis of client, sessions expire when not seen for expire,
+// 30 minutes if 0.
+func NewSessionRecords(client *redis.Client, expire time.Duration) *SessionRecords {
+	if expire <= 0 {
+		expire = 30 * time.Minute
+	}
+	return &SessionRecords{
+		client: client,
+		expire: expire,
+	}
+}
+
+var _ session.Records = (*SessionRecords)(nil)
+
+// touchSession updates last seen time of session of KEYS[1] to ARGV[1] and extends it by ARGV[2] milliseconds,
+// returning the user id. Expired session is not recreated.
+var touchSession = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+redis.call("HSET", KEYS[1], "seen", ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return redis.call("HGET", KEYS[1], "user")
+`)
+
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func userSessionsKey(id string) string {
+	return "sessions:user:" + id
+}
+
+func (r *SessionRecords) Add(ctx context.Context, id, sessionID string, device session.Device) error {
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	_, err := r.client.WithContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(sessionKey(sessionID), "user", id, "ua", device.UserAgent, "ip", device.RemoteIP,
+			"created", now, "seen", now)
+		pipe.Expire(sessionKey(sessionID), r.expire)
+		pipe.SAdd(userSessionsKey(id), sessionID)
+		pipe.Expire(userSessionsKey(id), r.expire)
+		return nil
+	})
+	return err
+}
+
+func (r *SessionRecords) Touch(ctx context.Context, sessionID string) (string, error) {
+	client := r.client.WithContext(ctx)
+	now := time.Now().UnixNano()
+	id, err := touchSession.Run(client, []string{sessionKey(sessionID)}, now, r.expire.Milliseconds()).Text()
+	if err == redis.Nil {
+		return "", session.ErrSessionNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	// set of the user's sessions lives as long as the last used one
+	client.Expire(userSessionsKey(id), r.expire)
+	return id, nil
+}
+
+func (r *SessionRecords) List(ctx context.Context, id string) ([]session.Session, error) {
+	client := r.client.WithContext(ctx)
+	sessionIDs, err := client.SMembers(userSessionsKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*redis.StringStringMapCmd, len(sessionIDs))
+	_, err = client.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, sessionID := range sessionIDs {
+			cmds[i] = pipe.HGetAll(sessionKey(sessionID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var sessions []session.Session
+	var expired []interface{}
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			expired = append(expired, sessionIDs[i])
+			continue
+		}
+		sessions = append(sessions, session.Session{
+			ID:       sessionIDs[i],
+			Device:   session.Device{UserAgent: fields["ua"], RemoteIP: fields["ip"]},
+			Created:  unixNano(fields["created"]),
+			LastSeen: unixNano(fields["seen"]),
+		})
+	}
+	// ids of expired sessions are left in the set until listed
+	if len(expired) > 0 {
+		client.SRem(userSessionsKey(id), expired...)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+func (r *SessionRecords) Owner(ctx context.Context, sessionID string) (string, error) {
+	id, err := r.client.WithContext(ctx).HGet(sessionKey(sessionID), "user").Result()
+	if err == redis.Nil {
+		return "", session.ErrSessionNotFound
+	}
+	return id, err
+}
+
+func (r *SessionRecords) Remove(ctx context.Context, id, sessionID string) error {
+	owner, err := r.Owner(ctx, sessionID)
+	if err == session.ErrSessionNotFound {
+		return nil
+	}
+	if err != nil || owner != id {
+		return err
+	}
+	_, err = r.client.WithContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(sessionKey(sessionID))
+		pipe.SRem(userSessionsKey(id), sessionID)
+		return nil
+	})
+	return err
+}
+
+func (r *SessionRecords) RemoveUser(ctx context.Context, id string) error {
+	client := r.client.WithContext(ctx)
+	sessionIDs, err := client.SMembers(userSessionsKey(id)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{userSessionsKey(id)}
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, sessionKey(sessionID))
+	}
+	return client.Del(keys...).Err()
+}
+
+// SessionStore is session.Manager keeping sessions on Redis shared by backend servers.
+// Session is recorded in SessionRecords under hash of it's token, which is the id of the session, and expires
+// when not used for idle expiration time, which is extended on every authentication.
+type SessionStore struct {
+	records *SessionRecords
+}
+
+// NewSessionStore create session store on Redis of client, sessions expire after idle for expire, 30 minutes if 0.
+func NewSessionStore(client *redis.Client, expire time.Duration) *SessionStore {
+	return &SessionStore{records: NewSessionRecords(client, expire)}
+}
+
+var _ session.Manager = (*SessionStore)(nil)
+
+func (s *SessionStore) Issue(ctx context.Context, id string, device session.Device) (string, string, error) {
+	token, err := session.NewID()
+	if err != nil {
+		return "", "", err
+	}
+	sessionID := session.HashID(token)
+	if err = s.records.Add(ctx, id, sessionID, device); err != nil {
+		return "", "", err
+	}
+	return token, sessionID, nil
+}
+
+func (s *SessionStore) Authenticate(ctx context.Context, token string) (string, string, error) {
+	sessionID := session.HashID(token)
+	id, err := s.records.Touch(ctx, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return id, sessionID, nil
+}
+
+func (s *SessionStore) Revoke(ctx context.Context, token string) error {
+	sessionID := session.HashID(token)
+	id, err := s.records.Owner(ctx, sessionID)
+	if err == session.ErrSessionNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.records.Remove(ctx, id, sessionID)
+}
+
+func (s *SessionStore) RevokeUser(ctx context.Context, id string) error {
+	return s.records.RemoveUser(ctx, id)
+}
+
+func (s *SessionStore) List(ctx context.Context, id string) ([]session.Session, error) {
+	return s.records.List(ctx, id)
+}
+
+func (s *SessionStore) RevokeSession(ctx context.Context, id, sessionID string) error {
+	return s.records.Remove(ctx, id, sessionID)
+}
+
+func unixNano(s string) time.Time {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(0, n)
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+)
+
+func TestSessionStore(t *testing.T) {
+	client, _ := newTestClient(t)
+	s := NewSessionStore(client, time.Hour)
+	ctx := context.Background()
+	token, sessionID, err := s.Issue(ctx, "song", session.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := s.Issue(ctx, "song", session.Device{})
+	if id, sid, err := s.Authenticate(ctx, token); id != "song" || sid != sessionID || err != nil {
+		t.Errorf("got %q, %q, %v", id, sid, err)
+	}
+	if _, _, err := s.Authenticate(ctx, "unknown"); err != session.ErrSessionNotFound {
+		t.Errorf("unknown session: got %v", err)
+	}
+	// revoking one session leaves the other
+	if err := s.Revoke(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Authenticate(ctx, token); err != session.ErrSessionNotFound {
+		t.Errorf("revoked session: got %v", err)
+	}
+	if _, _, err := s.Authenticate(ctx, other); err != nil {
+		t.Errorf("other session: %v", err)
+	}
+	if err := s.RevokeUser(ctx, "song"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Authenticate(ctx, other); err != session.ErrSessionNotFound {
+		t.Errorf("session of revoked user: got %v", err)
+	}
+}
+
+func TestSessionStoreSlidingExpiration(t *testing.T) {
+	client, server := newTestClient(t)
+	s := NewSessionStore(client, time.Minute)
+	ctx := context.Background()
+	token, _, _ := s.Issue(ctx, "song", session.Device{})
+	idle, _, _ := s.Issue(ctx, "song", session.Device{})
+	// used session is extended, idle one expires
+	for i := 0; i < 4; i++ {
+		server.FastForward(40 * time.Second)
+		if _, _, err := s.Authenticate(ctx, token); err != nil {
+			t.Fatalf("used session expired: %v", err)
+		}
+	}
+	if _, _, err := s.Authenticate(ctx, idle); err != session.ErrSessionNotFound {
+		t.Errorf("idle session: got %v", err)
+	}
+	if sessions, _ := s.List(ctx, "song"); len(sessions) != 1 {
+		t.Errorf("expired session is listed: got %+v", sessions)
+	}
+}
+
+func TestSessionStoreSessions(t *testing.T) {
+	client, _ := newTestClient(t)
+	s := NewSessionStore(client, time.Hour)
+	ctx := context.Background()
+	_, phone, _ := s.Issue(ctx, "song", session.Device{UserAgent: "phone", RemoteIP: "10.0.0.1"})
+	laptopToken, laptop, _ := s.Issue(ctx, "song", session.Device{UserAgent: "laptop", RemoteIP: "10.0.0.2"})
+	s.Issue(ctx, "other", session.Device{UserAgent: "other"})
+	time.Sleep(time.Millisecond)
+	s.Authenticate(ctx, laptopToken)
+
+	sessions, err := s.List(ctx, "song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != laptop || sessions[1].ID != phone {
+		t.Fatalf("sessions: got %+v", sessions)
+	}
+	if sessions[1].UserAgent != "phone" || sessions[1].RemoteIP != "10.0.0.1" {
+		t.Errorf("device: got %+v", sessions[1].Device)
+	}
+	if !sessions[0].LastSeen.After(sessions[0].Created) {
+		t.Error("last seen is not updated on authentication")
+	}
+
+	// session of other user is not revoked
+	s.RevokeSession(ctx, "other", laptop)
+	if _, _, err := s.Authenticate(ctx, laptopToken); err != nil {
+		t.Errorf("session revoked by other user: %v", err)
+	}
+	s.RevokeSession(ctx, "song", laptop)
+	if _, _, err := s.Authenticate(ctx, laptopToken); err != session.ErrSessionNotFound {
+		t.Errorf("revoked session: got %v", err)
+	}
+	if sessions, _ := s.List(ctx, "song"); len(sessions) != 1 || sessions[0].ID != phone {
+		t.Errorf("sessions after revoke: got %+v", sessions)
+	}
+}
+
+func TestSessionRecordsOwner(t *testing.T) {
+	client, _ := newTestClient(t)
+	r := NewSessionRecords(client, time.Hour)
+	ctx := context.Background()
+	if err := r.Add(ctx, "song", "session", session.Device{}); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := r.Owner(ctx, "session"); id != "song" || err != nil {
+		t.Errorf("got %q, %v", id, err)
+	}
+	if _, err := r.Owner(ctx, "unknown"); err != session.ErrSessionNotFound {
+		t.Errorf("unknown session: got %v", err)
+	}
+}
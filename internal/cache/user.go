@@ -11,11 +11,7 @@ type UserCache struct {
 
 func NewUserCache(host, port string) *UserCache {
 	return &UserCache{
-		client: redis.NewClient(&redis.Options{
-			Addr:     host + ":" + port,
-			Password: "",
-			DB:       0,
-		}),
+		client: NewClient(host, port),
 	}
 }
 
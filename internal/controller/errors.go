@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"git.garena.com/youngiek.song/entry_task/pkg/message"
+	"go.uber.org/zap"
+)
+
+// statusByCode maps error codes of backend TCP server to HTTP status.
+var statusByCode = map[message.ErrorCode]int{
+	message.ErrorCode_AUTH_FAILED:       http.StatusForbidden,
+	message.ErrorCode_INVALID_INPUT:     http.StatusBadRequest,
+	message.ErrorCode_NOT_FOUND:         http.StatusNotFound,
+	message.ErrorCode_RATE_LIMITED:      http.StatusTooManyRequests,
+	message.ErrorCode_ALREADY_EXISTS:    http.StatusConflict,
+	message.ErrorCode_PERMISSION_DENIED: http.StatusForbidden,
+	message.ErrorCode_DB_ERROR:          http.StatusInternalServerError,
+	message.ErrorCode_UNKNOWN:           http.StatusInternalServerError,
+	message.ErrorCode_INTERNAL:          http.StatusInternalServerError,
+}
+
+// backendStatus returns HTTP status for error returned by backend TCP server, with message shown to the user.
+func backendStatus(err error) (int, string) {
+	var e *message.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "Server timeout."
+	case errors.Is(err, message.ErrNoStream):
+		return http.StatusServiceUnavailable, "Server is restarting, try again."
+	case errors.As(err, &e):
+		status, ok := statusByCode[e.Code]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		return status, e.Error()
+	default:
+		return http.StatusInternalServerError, "Server error."
+	}
+}
+
+// writeBackendError responds with HTTP status mapped from error of backend TCP server and logs it.
+func (controller *UserController) writeBackendError(w http.ResponseWriter, r *http.Request, err error, fields ...zap.Field) {
+	status, msg := backendStatus(err)
+	fields = append([]zap.Field{zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error())}, fields...)
+	if status >= http.StatusInternalServerError {
+		controller.logger.Error("Fail communicating backend server", fields...)
+	} else {
+		controller.logger.Warn("Request rejected by backend server", fields...)
+	}
+	w.WriteHeader(status)
+	fmt.Fprintln(w, msg)
+}
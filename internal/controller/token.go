@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
+	"git.garena.com/youngiek.song/entry_task/pkg/message"
+	"go.uber.org/zap"
+)
+
+// setTokenCookies gives user access token and refresh token as cookies. Refresh token is empty if backend keeps
+// sessions by itself, and no cookie is set for it.
+func setTokenCookies(w http.ResponseWriter, token, refreshToken string) {
+	http.SetCookie(w, &http.Cookie{Name: "access_token", Value: token, Path: "/"})
+	if refreshToken != "" {
+		http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: refreshToken, Path: "/", HttpOnly: true})
+	}
+}
+
+// clearTokenCookies removes user's token cookies.
+func clearTokenCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "access_token", Value: "", Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+}
+
+// accessToken returns user's access token from cookie. If it is missing or expired and the user has refresh token,
+// tokens are refreshed by backend TCP server and given to the user as cookies. Error is returned only if the user
+// has no access token at all, expired one is returned to be rejected by backend TCP server.
+func (controller *UserController) accessToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	tokenCookie, err := r.Cookie("access_token")
+	if err == nil && !jwt.TokenExpired(tokenCookie.Value) {
+		return tokenCookie.Value, nil
+	}
+	refreshCookie, refreshErr := r.Cookie("refresh_token")
+	if refreshErr == nil {
+		ctx, cancel := controller.backendContext(r)
+		defer cancel()
+		token, refreshToken, refreshErr := controller.client.RefreshToken(ctx, refreshCookie.Value)
+		if refreshErr == nil {
+			setTokenCookies(w, token, refreshToken)
+			controller.logger.Info("Access token refreshed", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path))
+			return token, nil
+		}
+		controller.logger.Warn("Fail refreshing access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", refreshErr.Error()))
+		if errors.Is(refreshErr, message.ErrAuth) {
+			http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return tokenCookie.Value, nil
+}
+
+// tokenID returns id of the owner of access token verified with public keys of backend TCP server.
+// If the token is signed by key which is not published, like secret key of backend, id is returned without
+// verification and verified is false, so that the token is checked by backend.
+// Session id of backend using server-side sessions has no id in it, empty id is returned for it.
+func (controller *UserController) tokenID(token string) (id string, verified bool, err error) {
+	if !jwt.IsJWT(token) {
+		return "", false, nil
+	}
+	id, err = controller.verifier.AuthenticateToken(token)
+	if errors.Is(err, jwt.ErrKeyNotFound) {
+		id, err = jwt.GetIDFromToken(token)
+		return id, false, err
+	}
+	return id, err == nil, err
+}
@@ -1,11 +1,15 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"text/template"
+	"time"
 
 	"git.garena.com/youngiek.song/entry_task/internal/cache"
 	"git.garena.com/youngiek.song/entry_task/internal/jwt"
@@ -15,22 +19,44 @@ import (
 
 // UserController provides handler functions for http server. UserController is also able to access injected dependecies.
 type UserController struct {
-	client  *message.Client
-	cache   *cache.UserCache
-	logger  *zap.Logger
-	docRoot string
+	client   *message.Client
+	cache    *cache.UserCache
+	verifier *jwt.TokenVerifier // verifies access tokens with public keys of backend TCP server
+	logger   *zap.Logger
+	docRoot  string
+	timeout  time.Duration // maximum time to wait for backend TCP server per http request
 }
 
 // NewUserController create new instance of user controller with injected dependencies.
-func NewUserController(client *message.Client, cache *cache.UserCache, logger *zap.Logger, docRoot string) *UserController {
+func NewUserController(client *message.Client, cache *cache.UserCache, verifier *jwt.TokenVerifier, logger *zap.Logger, docRoot string, timeout time.Duration) *UserController {
 	return &UserController{
-		client:  client,
-		cache:   cache,
-		logger:  logger,
-		docRoot: docRoot,
+		client:   client,
+		cache:    cache,
+		verifier: verifier,
+		logger:   logger,
+		docRoot:  docRoot,
+		timeout:  timeout,
 	}
 }
 
+// backendContext returns context for requests to backend TCP server made while handling r.
+// It is cancelled when client of r goes away or the controller's timeout passes.
+func (controller *UserController) backendContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if controller.timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), controller.timeout)
+}
+
+// deviceOf returns device of user of r, recorded with the session on login.
+func deviceOf(r *http.Request) *message.Device {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return &message.Device{UserAgent: r.UserAgent(), RemoteIp: ip}
+}
+
 // LoginPage shows login page to user.
 func (controller *UserController) LoginPage(w http.ResponseWriter, r *http.Request) {
 	t, _ := template.ParseFiles(controller.docRoot + "/template/login.html")
@@ -43,125 +69,190 @@ func (controller *UserController) Login(w http.ResponseWriter, r *http.Request)
 	id := r.PostFormValue("id")
 	passwd := r.PostFormValue("pwd")
 
-	token, err := controller.client.Login(id, passwd)
-	if err != nil {
-		switch err.(type) {
-		case message.AuthError:
-			controller.logger.Warn("Wrong Id/Password", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("id", id), zap.String("error", err.Error()))
-			w.WriteHeader(http.StatusForbidden)
-			fmt.Fprintln(w, "Wrong ID/Password.", err)
-		default:
-			controller.logger.Error("Fail communicating backend server", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error()))
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, "Server error.", err)
-		}
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	token, refreshToken, err := controller.client.Login(ctx, id, passwd, deviceOf(r))
+	if err != nil {
+		controller.writeBackendError(w, r, err, zap.String("id", id))
 		return
 	}
-	http.SetCookie(w, &http.Cookie{Name: "access_token", Value: token, Path: "/"})
+	setTokenCookies(w, token, refreshToken)
 	http.Redirect(w, r, "/main", 302)
 	controller.logger.Info("Login request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path))
 }
 
-// Main shows user main page which contains user's information.
+// SignupPage shows sign up page to user.
+func (controller *UserController) SignupPage(w http.ResponseWriter, r *http.Request) {
+	t, _ := template.ParseFiles(controller.docRoot + "/template/signup.html")
+	t.Execute(w, nil)
+}
+
+// Signup creates new user by sending create user request to backend TCP server.
+// If successful, logs the user in by issuing JWT access token to user's cookie and redirects to main page.
+func (controller *UserController) Signup(w http.ResponseWriter, r *http.Request) {
+	id := r.PostFormValue("id")
+	passwd := r.PostFormValue("pwd")
+	nickname := r.PostFormValue("nickname")
+	if passwd != r.PostFormValue("pwd_confirm") {
+		controller.logger.Info("Password confirmation mismatch", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("id", id))
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Passwords do not match.")
+		return
+	}
+
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	err := controller.client.CreateUser(ctx, id, passwd, nickname)
+	if err != nil {
+		controller.writeBackendError(w, r, err, zap.String("id", id))
+		return
+	}
+	token, refreshToken, err := controller.client.Login(ctx, id, passwd, deviceOf(r))
+	if err != nil {
+		controller.writeBackendError(w, r, err, zap.String("id", id))
+		return
+	}
+	setTokenCookies(w, token, refreshToken)
+	http.Redirect(w, r, "/main", 302)
+	controller.logger.Info("Signup request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("id", id))
+}
+
+// mainPage is data of main page, the user with it's sessions.
+type mainPage struct {
+	*message.User
+	Sessions []sessionRow
+}
+
+// sessionRow is a session listed on main page.
+type sessionRow struct {
+	ID        string
+	UserAgent string
+	RemoteIP  string
+	Created   string
+	LastSeen  string
+	Current   bool
+}
+
+// sessionRows formats sessions to be listed on main page.
+func sessionRows(sessions []*message.Session) []sessionRow {
+	rows := make([]sessionRow, 0, len(sessions))
+	for _, s := range sessions {
+		rows = append(rows, sessionRow{
+			ID:        s.Id,
+			UserAgent: s.Device.GetUserAgent(),
+			RemoteIP:  s.Device.GetRemoteIp(),
+			Created:   time.Unix(s.Created, 0).Format("2006-01-02 15:04:05"),
+			LastSeen:  time.Unix(s.LastSeen, 0).Format("2006-01-02 15:04:05"),
+			Current:   s.Current,
+		})
+	}
+	return rows
+}
+
+// Main shows user main page which contains user's information and sessions of the user on each device.
 // User should have JWT access token as cookie to retrieve the information from backend TCP server.
-// After retrieving user info from backend server, it draws main page with the information.
+// Token is verified locally with public keys of backend TCP server, so that page of cached user is drawn without
+// request to backend. Tokens signed by secret key of backend are verified by backend.
+// After retrieving user info from backend server, it draws main page with the information. Sessions are always
+// listed by backend, the page is drawn without them if it fails.
 func (controller *UserController) Main(w http.ResponseWriter, r *http.Request) {
-	tokenCookie, err := r.Cookie("access_token")
+	token, err := controller.accessToken(w, r)
 	if err != nil {
 		controller.logger.Info("No access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error()))
 		w.WriteHeader(http.StatusUnauthorized)
 		fmt.Fprintln(w, "Can't access this page. You don't have access token.", err)
 		return
 	}
-	id, err := jwt.GetIDFromToken(tokenCookie.Value)
+	id, verified, err := controller.tokenID(token)
 	if err != nil {
-		controller.logger.Warn("No ID claim in access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value), zap.String("error", err.Error()))
+		controller.logger.Warn("Invalid access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", token), zap.String("error", err.Error()))
+		if errors.Is(err, jwt.ErrTokenRevoked) {
+			clearTokenCookies(w)
+		}
 		w.WriteHeader(http.StatusUnauthorized)
 		fmt.Fprintln(w, "Can't access this page. Invalid access token.", err)
 		return
 	}
-	user, err := controller.cache.GetUserInfo(id)
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	// user of session id is known only to backend, it is not looked up in cache
+	var user *message.User
+	if id != "" {
+		user, err = controller.cache.GetUserInfo(id)
+	}
 	if err == nil && user != nil {
-		err = controller.client.Authenticate(tokenCookie.Value)
+		if !verified {
+			err = controller.client.Authenticate(ctx, token)
+		}
 		if err != nil {
-			switch err.(type) {
-			case message.AuthError:
-				http.SetCookie(w, &http.Cookie{Name: "access_token", Value: "", Path: "/", MaxAge: -1})
-				controller.logger.Warn("Access token authentication fail", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value), zap.String("error", err.Error()))
-				w.WriteHeader(http.StatusForbidden)
-				fmt.Fprintln(w, "Server Error.", err)
-			default:
-				controller.logger.Error("Fail communicating backend server", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value), zap.String("error", err.Error()))
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprintln(w, "Server Error.", err)
+			if errors.Is(err, message.ErrAuth) {
+				clearTokenCookies(w)
 			}
+			controller.writeBackendError(w, r, err, zap.String("token", token))
 			return
 		}
 	} else {
-		user, err = controller.client.GetUserInfo(tokenCookie.Value)
+		user, err = controller.client.GetUserInfo(ctx, token)
 		if err != nil {
-			controller.logger.Error("Fail communicating backend server", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value), zap.String("error", err.Error()))
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, "Server error", err)
+			if errors.Is(err, message.ErrAuth) {
+				clearTokenCookies(w)
+			}
+			controller.writeBackendError(w, r, err, zap.String("token", token))
 			return
 		}
 		controller.cache.SetUserInfo(user)
 	}
+	page := mainPage{User: user}
+	sessions, err := controller.client.ListSessions(ctx, token)
+	if err != nil {
+		controller.logger.Warn("Fail listing sessions", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error()))
+	} else {
+		page.Sessions = sessionRows(sessions)
+	}
 	t, _ := template.ParseFiles(controller.docRoot + "/template/main.html")
-	t.Execute(w, user)
-	controller.logger.Info("request success", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value))
+	t.Execute(w, page)
+	controller.logger.Info("request success", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", token))
 }
 
 // EditUserInfo modify user's information.
-// User should have JWT access token as cookie to retrieve the information from backend TCP server.
+// User should have access token as cookie to retrieve the information from backend TCP server, which edits the owner
+// of the token.
 // After successfully modifying user info from backend server, it redirect to main page.
 func (controller *UserController) EditUserInfo(w http.ResponseWriter, r *http.Request) {
-	tokenCookie, err := r.Cookie("access_token")
+	token, err := controller.accessToken(w, r)
 	if err != nil {
 		controller.logger.Error("No access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error()))
 		w.WriteHeader(http.StatusUnauthorized)
 		fmt.Fprintln(w, "Can't access this page. You don't have access token.", err)
 		return
 	}
-	id, err := jwt.GetIDFromToken(tokenCookie.Value)
-	if err != nil {
-		controller.logger.Warn("No ID claim in access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value), zap.String("error", err.Error()))
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprintln(w, "Can't access this page. Invalid access token.", err)
-		return
-	}
 	nickname := r.PostFormValue("nickname")
 
-	err = controller.client.EditUserInfo(tokenCookie.Value, &message.User{
-		Id:       id,
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	err = controller.client.EditUserInfo(ctx, token, &message.User{
 		Nickname: nickname,
 	})
 	if err != nil {
-		switch err.(type) {
-		case message.AuthError:
-			controller.logger.Warn("Access token authentication fail", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value), zap.String("error", err.Error()))
-			w.WriteHeader(http.StatusForbidden)
-			fmt.Fprintln(w, "Server Error.", err)
-		default:
-			controller.logger.Error("Fail communicating backend server", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error()))
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, "Server error", err)
-		}
+		controller.writeBackendError(w, r, err, zap.String("token", token))
 		return
 	}
-	err = controller.cache.DelUserInfo(id)
-	if err != nil {
-		controller.logger.Error("Delete user cache fail", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("id", id), zap.String("error", err.Error()))
+	// user of session id is not looked up in cache by Main, so only owner of JWT is removed from it
+	if id, _, _ := controller.tokenID(token); id != "" {
+		err = controller.cache.DelUserInfo(id)
+		if err != nil {
+			controller.logger.Error("Delete user cache fail", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("id", id), zap.String("error", err.Error()))
+		}
 	}
 	http.Redirect(w, r, "/main", 302)
-	controller.logger.Info("request success", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value))
+	controller.logger.Info("request success", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", token))
 }
 
 // UploadPhoto uploads user's profile picture.
 // User should have JWT access token as cookie to authenticate your access priviligies from TCP backend server.
 // After successfully modifying picture, it redirect to main page.
 func (controller *UserController) UploadPhoto(w http.ResponseWriter, r *http.Request) {
-	tokenCookie, err := r.Cookie("access_token")
+	token, err := controller.accessToken(w, r)
 	if err != nil {
 		controller.logger.Error("No access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error()))
 		w.WriteHeader(http.StatusUnauthorized)
@@ -177,18 +268,11 @@ func (controller *UserController) UploadPhoto(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	user, err := controller.client.GetUserInfo(tokenCookie.Value)
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	user, err := controller.client.GetUserInfo(ctx, token)
 	if err != nil {
-		switch err.(type) {
-		case message.AuthError:
-			controller.logger.Warn("Access token authentication fail", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value), zap.String("error", err.Error()))
-			w.WriteHeader(http.StatusForbidden)
-			fmt.Fprintln(w, "Server Error.", err)
-		default:
-			controller.logger.Error("Fail communicating backend server", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value), zap.String("error", err.Error()))
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintln(w, "Server error.", err)
-		}
+		controller.writeBackendError(w, r, err, zap.String("token", token))
 		return
 	}
 
@@ -208,5 +292,140 @@ func (controller *UserController) UploadPhoto(w http.ResponseWriter, r *http.Req
 		return
 	}
 	http.Redirect(w, r, "/main", 302)
-	controller.logger.Info("UploadPhoto request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", tokenCookie.Value))
+	controller.logger.Info("UploadPhoto request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("token", token))
+}
+
+// ChangePassword replaces user's password after checking the current one.
+// All sessions of the user are revoked by backend TCP server, so the user is logged out and redirected to login page.
+func (controller *UserController) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	token, err := controller.accessToken(w, r)
+	if err != nil {
+		controller.logger.Error("No access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "Can't access this page. You don't have access token.", err)
+		return
+	}
+	newPasswd := r.PostFormValue("new_pwd")
+	if newPasswd != r.PostFormValue("new_pwd_confirm") {
+		controller.logger.Info("Password confirmation mismatch", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path))
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Passwords do not match.")
+		return
+	}
+
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	err = controller.client.ChangePassword(ctx, token, r.PostFormValue("current_pwd"), newPasswd)
+	if err != nil {
+		controller.writeBackendError(w, r, err, zap.String("token", token))
+		return
+	}
+	clearTokenCookies(w)
+	http.Redirect(w, r, "/", 302)
+	controller.logger.Info("ChangePassword request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path))
+}
+
+// PasswordResetPage shows page to request password reset token.
+func (controller *UserController) PasswordResetPage(w http.ResponseWriter, r *http.Request) {
+	t, _ := template.ParseFiles(controller.docRoot + "/template/reset_request.html")
+	t.Execute(w, nil)
+}
+
+// RequestPasswordReset asks backend TCP server to send password reset token to the user.
+// Same answer is shown whether the user exists or not.
+func (controller *UserController) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	id := r.PostFormValue("id")
+
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	err := controller.client.RequestPasswordReset(ctx, id)
+	if err != nil {
+		controller.writeBackendError(w, r, err, zap.String("id", id))
+		return
+	}
+	fmt.Fprintln(w, "If the user exists, password reset token has been sent.")
+	controller.logger.Info("RequestPasswordReset request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("id", id))
+}
+
+// ResetPasswordPage shows page to set new password with reset token, filled in from token query parameter.
+func (controller *UserController) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	t, _ := template.ParseFiles(controller.docRoot + "/template/reset.html")
+	t.Execute(w, r.URL.Query().Get("token"))
+}
+
+// ResetPassword sets new password with reset token, and redirects to login page.
+func (controller *UserController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	newPasswd := r.PostFormValue("new_pwd")
+	if newPasswd != r.PostFormValue("new_pwd_confirm") {
+		controller.logger.Info("Password confirmation mismatch", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path))
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Passwords do not match.")
+		return
+	}
+
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	err := controller.client.ResetPassword(ctx, r.PostFormValue("token"), newPasswd)
+	if err != nil {
+		controller.writeBackendError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/", 302)
+	controller.logger.Info("ResetPassword request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path))
+}
+
+// Logout revokes user's access token and refresh token, or all of the user's tokens if all_devices is set,
+// and clears the cookies.
+// The user is redirected to login page even if the token is invalid already.
+func (controller *UserController) Logout(w http.ResponseWriter, r *http.Request) {
+	token, err := controller.accessToken(w, r)
+	if err != nil {
+		http.Redirect(w, r, "/", 302)
+		return
+	}
+	allDevices := r.PostFormValue("all_devices") != ""
+
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	var refreshToken string
+	if refreshCookie, err := r.Cookie("refresh_token"); err == nil {
+		refreshToken = refreshCookie.Value
+	}
+	err = controller.client.Logout(ctx, token, refreshToken, allDevices)
+	if err != nil && !errors.Is(err, message.ErrAuth) {
+		controller.writeBackendError(w, r, err, zap.String("token", token))
+		return
+	}
+	clearTokenCookies(w)
+	http.Redirect(w, r, "/", 302)
+	controller.logger.Info("Logout request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.Bool("all_devices", allDevices))
+}
+
+// RevokeSession signs user out of one of it's devices, the session of session_id listed on main page.
+// If it is the session of the request, given as current, cookies are cleared and the user is redirected to login
+// page. Otherwise the user is redirected to main page.
+func (controller *UserController) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	token, err := controller.accessToken(w, r)
+	if err != nil {
+		controller.logger.Error("No access token", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path), zap.String("error", err.Error()))
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "Can't access this page. You don't have access token.", err)
+		return
+	}
+	sessionID := r.PostFormValue("session_id")
+
+	ctx, cancel := controller.backendContext(r)
+	defer cancel()
+	err = controller.client.RevokeSession(ctx, token, sessionID)
+	if err != nil {
+		controller.writeBackendError(w, r, err, zap.String("token", token))
+		return
+	}
+	if r.PostFormValue("current") != "" {
+		clearTokenCookies(w)
+		http.Redirect(w, r, "/", 302)
+	} else {
+		http.Redirect(w, r, "/main", 302)
+	}
+	controller.logger.Info("RevokeSession request", zap.String("remote", r.RemoteAddr), zap.String("path", r.URL.Path))
 }
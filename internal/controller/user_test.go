@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/cache"
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
+	"git.garena.com/youngiek.song/entry_task/internal/models"
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+	"git.garena.com/youngiek.song/entry_task/pkg/message"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestController returns controller of backend keeping sessions in memory, which gives opaque session ids as
+// access tokens, with users song and kim.
+func newTestController(t *testing.T) (*UserController, *message.Client) {
+	store := models.NewMemoryStore(models.NewBcryptHasher(bcrypt.MinCost))
+	store.CreateUser(context.Background(), &models.User{Id: "song", Password: "passw0rd", Nickname: "song"})
+	store.CreateUser(context.Background(), &models.User{Id: "kim", Password: "passw0rd", Nickname: "kim"})
+	config := message.ServerConfig{Sessions: session.NewMemoryStore(time.Hour)}
+	server := message.NewServer("127.0.0.1", "0", config, store, jwt.NewTokenIssuer("key", time.Hour), zap.NewNop())
+	go server.Run()
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+	client := message.NewClient("127.0.0.1", port, message.PoolConfig{MaxConn: 2, MaxCalls: 4})
+	// cache is not used for users of session ids, nothing listens on it
+	userCache := cache.NewUserCache("127.0.0.1", "1")
+	return NewUserController(client, userCache, nil, zap.NewNop(), "../../web", time.Second), client
+}
+
+func TestEditUserInfoSessionToken(t *testing.T) {
+	controller, client := newTestController(t)
+	ctx := context.Background()
+	token, _, err := client.Login(ctx, "song", "passw0rd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwt.IsJWT(token) {
+		t.Fatalf("got JWT %q, want session id", token)
+	}
+
+	form := url.Values{"nickname": {"new song"}}
+	r := httptest.NewRequest("POST", "/main", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	w := httptest.NewRecorder()
+	controller.EditUserInfo(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	user, err := client.GetUserInfo(ctx, token)
+	if err != nil || user.Nickname != "new song" {
+		t.Errorf("got %v, %v", user, err)
+	}
+	other, _, _ := client.Login(ctx, "kim", "passw0rd", nil)
+	if user, err = client.GetUserInfo(ctx, other); err != nil || user.Nickname != "kim" {
+		t.Errorf("other user: got %v, %v", user, err)
+	}
+}
+
+func TestMainSessions(t *testing.T) {
+	controller, client := newTestController(t)
+	ctx := context.Background()
+	token, _, _ := client.Login(ctx, "song", "passw0rd", &message.Device{UserAgent: "laptop"})
+	client.Login(ctx, "song", "passw0rd", &message.Device{UserAgent: "phone"})
+
+	r := httptest.NewRequest("GET", "/main", nil)
+	r.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+	w := httptest.NewRecorder()
+	controller.Main(w, r)
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "laptop") || !strings.Contains(body, "phone") {
+		t.Errorf("got status %d: %s", w.Code, body)
+	}
+	if strings.Count(body, "this device") != 1 {
+		t.Errorf("current session is not marked: %s", body)
+	}
+
+	r = httptest.NewRequest("GET", "/main", nil)
+	r.AddCookie(&http.Cookie{Name: "access_token", Value: "unknown"})
+	w = httptest.NewRecorder()
+	controller.Main(w, r)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Header().Get("Set-Cookie"), "access_token=;") {
+		t.Errorf("unknown token: got status %d, cookies %v", w.Code, w.Header()["Set-Cookie"])
+	}
+}
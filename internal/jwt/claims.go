@@ -0,0 +1,147 @@
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// Errors of token validation, returned by AuthenticateToken so that callers tell why token is rejected.
+var (
+	ErrMalformedToken    = errors.New("token is malformed")
+	ErrInvalidSignature  = errors.New("token signature is invalid")
+	ErrTokenNotValidYet  = errors.New("token is not valid yet")
+	ErrInvalidIssuer     = errors.New("token has wrong issuer")
+	ErrInvalidAudience   = errors.New("token has wrong audience")
+	ErrTokenMissingClaim = errors.New("token is missing required claim")
+)
+
+// Claims are claims of tokens issued by TokenIssuer, registered claims of RFC 7519.
+type Claims struct {
+	Issuer    string    // iss, issuer of the token
+	Subject   string    // sub, id of the user
+	Audience  []string  // aud, recipients the token is intended for
+	ID        string    // jti, unique id of the token
+	SessionID string    // sid, login session the token is issued for, all tokens of it are revoked together
+	IssuedAt  time.Time // iat, with sub-second precision
+	NotBefore time.Time // nbf
+	ExpiresAt time.Time // exp
+}
+
+// jsonClaims is JSON form of Claims. Dates are NumericDate, seconds since epoch which may be fractional.
+type jsonClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  interface{} `json:"aud,omitempty"` // string, or array of strings
+	ID        string      `json:"jti,omitempty"`
+	SessionID string      `json:"sid,omitempty"`
+	IssuedAt  float64     `json:"iat,omitempty"`
+	NotBefore float64     `json:"nbf,omitempty"`
+	ExpiresAt float64     `json:"exp,omitempty"`
+	UserID    string      `json:"id,omitempty"` // id of the user in tokens issued before sub, still read by older servers
+}
+
+// MarshalJSON encodes claims as JWT claims set.
+func (c Claims) MarshalJSON() ([]byte, error) {
+	jc := jsonClaims{
+		Issuer:    c.Issuer,
+		Subject:   c.Subject,
+		ID:        c.ID,
+		SessionID: c.SessionID,
+		IssuedAt:  numericDate(c.IssuedAt),
+		NotBefore: numericDate(c.NotBefore),
+		ExpiresAt: numericDate(c.ExpiresAt),
+		UserID:    c.Subject,
+	}
+	switch len(c.Audience) {
+	case 0:
+	case 1:
+		jc.Audience = c.Audience[0]
+	default:
+		jc.Audience = c.Audience
+	}
+	return json.Marshal(jc)
+}
+
+// UnmarshalJSON decodes JWT claims set. id claim is taken as subject of tokens without sub.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	var jc jsonClaims
+	if err := json.Unmarshal(data, &jc); err != nil {
+		return err
+	}
+	*c = Claims{
+		Issuer:    jc.Issuer,
+		Subject:   jc.Subject,
+		ID:        jc.ID,
+		SessionID: jc.SessionID,
+		IssuedAt:  numericTime(jc.IssuedAt),
+		NotBefore: numericTime(jc.NotBefore),
+		ExpiresAt: numericTime(jc.ExpiresAt),
+	}
+	if c.Subject == "" {
+		c.Subject = jc.UserID
+	}
+	switch aud := jc.Audience.(type) {
+	case nil:
+	case string:
+		c.Audience = []string{aud}
+	case []interface{}:
+		for _, v := range aud {
+			s, ok := v.(string)
+			if !ok {
+				return errors.New("aud claim must be string or array of strings")
+			}
+			c.Audience = append(c.Audience, s)
+		}
+	default:
+		return errors.New("aud claim must be string or array of strings")
+	}
+	return nil
+}
+
+// Valid checks time claims of c without leeway. It is called by jwt-go for claims parsed without TokenVerifier.
+func (c *Claims) Valid() error {
+	return c.validTime(time.Now(), 0)
+}
+
+// validTime checks that c is valid at now. Clocks of servers may differ by up to leeway.
+func (c *Claims) validTime(now time.Time, leeway time.Duration) error {
+	if c.ExpiresAt.IsZero() {
+		return ErrTokenMissingClaim
+	}
+	if !now.Before(c.ExpiresAt.Add(leeway)) {
+		return TokenExpiredError{}
+	}
+	if now.Add(leeway).Before(c.NotBefore) || now.Add(leeway).Before(c.IssuedAt) {
+		return ErrTokenNotValidYet
+	}
+	return nil
+}
+
+// hasAudience reports whether aud is one of the audience of c.
+func (c *Claims) hasAudience(aud string) bool {
+	for _, a := range c.Audience {
+		if a == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// numericDate converts time to NumericDate, 0 for zero time.
+func numericDate(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// numericTime converts NumericDate to time, zero time for 0.
+func numericTime(f float64) time.Time {
+	if f == 0 {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second)))
+}
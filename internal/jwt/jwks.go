@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// jsonWebKey is public key in JSON Web Key format (RFC 7517), Ed25519 keys are OKP keys of RFC 8037.
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`   // modulus of RSA key
+	E         string `json:"e,omitempty"`   // exponent of RSA key
+	Curve     string `json:"crv,omitempty"` // curve of OKP key
+	X         string `json:"x,omitempty"`   // public key of OKP key
+}
+
+// jsonWebKeySet is JSON Web Key Set document.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// MarshalJWKS encodes public keys of keys as JSON Web Key Set. Secret keys of HS256 are never published,
+// they are left out of the set.
+func MarshalJWKS(keys []Key) ([]byte, error) {
+	set := jsonWebKeySet{Keys: []jsonWebKey{}}
+	for _, k := range keys {
+		jwk := jsonWebKey{KeyID: k.ID, Algorithm: k.alg(), Use: "sig"}
+		switch public := k.public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return json.Marshal(set)
+}
+
+// ParseJWKS decodes JSON Web Key Set into verify-only keys. Keys of types other than RSA and Ed25519 are skipped.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set jsonWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	var keys []Key
+	for _, jwk := range set.Keys {
+		k := Key{ID: jwk.KeyID, Algorithm: jwk.Algorithm}
+		switch {
+		case jwk.KeyType == "RSA":
+			if k.Algorithm == "" {
+				k.Algorithm = AlgRS256
+			}
+			n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err != nil {
+				return nil, err
+			}
+			k.PublicKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519":
+			if k.Algorithm == "" {
+				k.Algorithm = AlgEdDSA
+			}
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			if err != nil {
+				return nil, err
+			}
+			if len(x) != ed25519.PublicKeySize {
+				return nil, errors.New("invalid Ed25519 key size")
+			}
+			k.PublicKey = ed25519.PublicKey(x)
+		default:
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// ErrNoSigningKey is returned by RemoteKeySet.SigningKey, it holds public keys of other issuer.
+var ErrNoSigningKey = errors.New("key set has no signing key")
+
+// minKeyFetchInterval limits how often RemoteKeySet fetches keys for tokens of unknown key id.
+const minKeyFetchInterval = 10 * time.Second
+
+// RemoteKeySet is KeySet of public keys published by token issuer as JSON Web Key Set, like by PublicKeys request
+// to backend server. Keys are fetched again every refresh interval, and when a token of unknown key id is verified,
+// at most once per minKeyFetchInterval, so that keys rotated by the issuer are picked up right away.
+// If fetching fails, keys fetched before are used.
+type RemoteKeySet struct {
+	fetch   func() ([]byte, error)
+	refresh time.Duration
+	mutex   sync.Mutex
+	keys    map[string]Key
+	fetched time.Time // last time keys were fetched, successfully or not
+	err     error     // error of last fetch
+}
+
+// NewRemoteKeySet create RemoteKeySet fetching JSON Web Key Set with fetch every refresh interval, 5 minutes if 0.
+// Keys are fetched lazily on first verification.
+func NewRemoteKeySet(fetch func() ([]byte, error), refresh time.Duration) *RemoteKeySet {
+	if refresh <= 0 {
+		refresh = 5 * time.Minute
+	}
+	return &RemoteKeySet{fetch: fetch, refresh: refresh}
+}
+
+// update fetches keys, mutex must be held.
+func (s *RemoteKeySet) update() {
+	s.fetched = time.Now()
+	data, err := s.fetch()
+	if err == nil {
+		var keys []Key
+		if keys, err = ParseJWKS(data); err == nil {
+			s.keys = make(map[string]Key, len(keys))
+			for _, k := range keys {
+				s.keys[k.ID] = k
+			}
+		}
+	}
+	s.err = err
+}
+
+// SigningKey always fails with ErrNoSigningKey, so tokens without key id are not verified by the set.
+func (s *RemoteKeySet) SigningKey() (Key, error) {
+	return Key{}, ErrNoSigningKey
+}
+
+// VerifyingKey returns public key of id, fetching keys if they are old or id is not known.
+func (s *RemoteKeySet) VerifyingKey(id string) (Key, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	since := time.Since(s.fetched)
+	if _, ok := s.keys[id]; since >= s.refresh || !ok && since >= minKeyFetchInterval {
+		s.update()
+	}
+	k, ok := s.keys[id]
+	return k, ok, nil
+}
+
+// Keys returns keys fetched last time.
+func (s *RemoteKeySet) Keys() ([]Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Err returns error of last fetch, nil if keys are up to date.
+func (s *RemoteKeySet) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
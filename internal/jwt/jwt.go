@@ -1,25 +1,92 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
-// TokenIssuer issues JWT token for user validation. Tokens have expiration time for security
+// TokenVerifier authenticates tokens issued by TokenIssuer. It verifies signature with keys, which are public keys
+// of the issuer when it signs tokens with asymmetric keys, so that tokens are verified without asking the issuer.
+type TokenVerifier struct {
+	keys        KeySet         // keys to verify signature of JWT
+	revocations RevocationList // revoked tokens consulted by AuthenticateToken
+	issuer      string         // expected iss claim, not checked if empty
+	audience    string         // expected to be one of aud claim, not checked if empty
+	leeway      time.Duration  // allowed clock skew between issuer and verifier
+}
+
+// NewTokenVerifier create TokenVerifier verifying tokens with keys, like RemoteKeySet of the issuer.
+// Revoked tokens are not known until SetRevocationList is called with the list of the issuer.
+func NewTokenVerifier(keys KeySet) *TokenVerifier {
+	return &TokenVerifier{
+		keys:        keys,
+		revocations: NewMemoryRevocationList(),
+	}
+}
+
+// TokenIssuer issues JWT token for user validation. Tokens have expiration time for security,
+// and can be revoked before it by RevokeToken and RevokeUser.
 type TokenIssuer struct {
-	key        string        // secret key to create signature of JWT
-	expireTime time.Duration // expiration duration of token
+	*TokenVerifier               // verifies tokens with keys of the issuer, which sign new tokens too
+	expireTime     time.Duration // expiration duration of token
+	audience       []string      // aud claim of tokens
 }
 
 // NewTokenIssuer create and return TokenIssuer with secret key and expiration time.
+// Revoked tokens are kept in memory until SetRevocationList is called.
 func NewTokenIssuer(key string, expireTime time.Duration) *TokenIssuer {
+	return NewKeySetTokenIssuer(NewStaticKeySet(Key{Secret: key}), expireTime)
+}
+
+// NewKeySetTokenIssuer create TokenIssuer signing tokens with keys, which can be rotated like KeyFile.
+func NewKeySetTokenIssuer(keys KeySet, expireTime time.Duration) *TokenIssuer {
 	return &TokenIssuer{
-		key:        key,
-		expireTime: expireTime,
+		TokenVerifier: NewTokenVerifier(keys),
+		expireTime:    expireTime,
+	}
+}
+
+// SetRevocationList makes verifier consult revoked tokens in list, like the one shared by servers on Redis.
+// TokenIssuer keeps tokens it revokes there too. It must be called before the verifier is used.
+func (verifier *TokenVerifier) SetRevocationList(list RevocationList) {
+	verifier.revocations = list
+}
+
+// ExpectClaims makes verifier reject tokens whose iss claim is not issuer, or whose aud claim does not contain
+// audience. Empty one is not checked. It must be called before the verifier is used.
+func (verifier *TokenVerifier) ExpectClaims(issuer, audience string) {
+	verifier.issuer = issuer
+	verifier.audience = audience
+}
+
+// SetLeeway makes verifier accept tokens expired or issued up to leeway ago or ahead of it's clock,
+// allowing for clock skew between servers. It must be called before the verifier is used.
+func (verifier *TokenVerifier) SetLeeway(leeway time.Duration) {
+	verifier.leeway = leeway
+}
+
+// SetIssuer makes issuer issue tokens with iss claim of name and aud claim of audience, and expect them of
+// tokens it verifies. Empty one is left out. It must be called before the issuer is used.
+func (issuer *TokenIssuer) SetIssuer(name, audience string) {
+	issuer.audience = nil
+	if audience != "" {
+		issuer.audience = []string{audience}
 	}
+	issuer.ExpectClaims(name, audience)
 }
 
+// ErrKeyNotFound is returned for token whose key id is not in the keys verifying it.
+var ErrKeyNotFound = errors.New("verifying key not found")
+
+// ErrTokenRevoked is returned by AuthenticateToken for token revoked by RevokeToken or RevokeUser.
+var ErrTokenRevoked = errors.New("token is revoked")
+
 // TokenExpiredError occurs when token exp field is before current time
 type TokenExpiredError struct{}
 
@@ -27,16 +94,50 @@ func (e TokenExpiredError) Error() string {
 	return "token has expired"
 }
 
-// GenerateToken generate JWT token with secret key and claims of user's id, expiration time and issue time.
+// GenerateToken generate JWT token with secret key and claims of user's id as subject, issuer, audience, expiration
+// time, issue time and unique token id. Issue time has sub-second precision so that tokens issued right after
+// revoking older ones are told apart.
+// Token is signed by signing key of the issuer's keys, whose id is set as kid header.
 // On succes, returns generated token. On fail return empty string
 func (issuer *TokenIssuer) GenerateToken(id string) string {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":  id,
-		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(issuer.expireTime).Unix(),
+	return issuer.GenerateSessionToken(id, "")
+}
+
+// GenerateSessionToken generate token like GenerateToken, which belongs to login session of sessionID.
+// Tokens of the session are revoked together by RevokeSession.
+func (issuer *TokenIssuer) GenerateSessionToken(id, sessionID string) string {
+	key, err := issuer.keys.SigningKey()
+	if err != nil {
+		return ""
+	}
+	method, err := key.signingMethod()
+	if err != nil {
+		return ""
+	}
+	signingKey, err := key.signingKey()
+	if err != nil {
+		return ""
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return ""
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(method, &Claims{
+		Issuer:    issuer.issuer,
+		Subject:   id,
+		Audience:  issuer.audience,
+		ID:        hex.EncodeToString(jti),
+		SessionID: sessionID,
+		IssuedAt:  now,
+		NotBefore: now,
+		ExpiresAt: now.Add(issuer.expireTime).Truncate(time.Second),
 	})
 
-	tokenString, err := token.SignedString([]byte(issuer.key))
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
 		return ""
 	}
@@ -44,31 +145,168 @@ func (issuer *TokenIssuer) GenerateToken(id string) string {
 }
 
 // AuthenticateToken check given JWT token's signature with it's secret key.
-// It also check expiration date and issue date. Any one of verification fails, returns error.
+// It also check expiration date, issue date, issuer, audience and revocation. Any one of verification fails,
+// returns error telling why, like TokenExpiredError, ErrInvalidSignature or ErrTokenRevoked.
 // On success return owner id of token.
-func (issuer *TokenIssuer) AuthenticateToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(issuer.key), nil
-	})
+func (verifier *TokenVerifier) AuthenticateToken(tokenString string) (string, error) {
+	c, err := verifier.ParseToken(tokenString)
 	if err != nil {
 		return "", err
 	}
-	// check expire date and issue date
-	err = token.Claims.Valid()
+	return c.Subject, nil
+}
+
+// ParseToken authenticates token like AuthenticateToken, and returns it's claims.
+func (verifier *TokenVerifier) ParseToken(tokenString string) (*Claims, error) {
+	c, err := verifier.parse(tokenString)
 	if err != nil {
-		return "", nil
+		return nil, err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims["id"].(string), nil
+	revoked, err := verifier.revocations.TokenRevoked(c.ID)
+	if err != nil {
+		return nil, err
+	}
+	if c.SessionID != "" && !revoked {
+		// sessions are revoked in the list same as tokens, by their id
+		if revoked, err = verifier.revocations.TokenRevoked(c.SessionID); err != nil {
+			return nil, err
+		}
+	}
+	revokedAt, err := verifier.revocations.UserRevokedAt(c.Subject)
+	if err != nil {
+		return nil, err
 	}
-	return "", nil
+	if revoked || !c.IssuedAt.After(revokedAt) {
+		return nil, ErrTokenRevoked
+	}
+	return c, nil
+}
+
+// parse verifies signature and claims of token except revocation, and returns the claims.
+func (verifier *TokenVerifier) parse(tokenString string) (*Claims, error) {
+	c := &Claims{}
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(tokenString, c, verifier.verifyingKey)
+	if ve, ok := err.(*jwt.ValidationError); ok {
+		switch {
+		case ve.Errors&jwt.ValidationErrorMalformed != 0:
+			return nil, fmt.Errorf("%w: %v", ErrMalformedToken, ve.Inner)
+		case ve.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return nil, ErrInvalidSignature
+		case ve.Inner != nil:
+			return nil, ve.Inner
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = c.validTime(time.Now(), verifier.leeway); err != nil {
+		return nil, err
+	}
+	if c.Subject == "" {
+		return nil, ErrTokenMissingClaim
+	}
+	if verifier.issuer != "" && c.Issuer != verifier.issuer {
+		return nil, ErrInvalidIssuer
+	}
+	if verifier.audience != "" && !c.hasAudience(verifier.audience) {
+		return nil, ErrInvalidAudience
+	}
+	return c, nil
+}
+
+// verifyingKey returns key verifying token by it's kid header. Tokens without kid, issued before keys had ids,
+// are verified by signing key. Algorithm of the token must be the one of the key, so that token signed with
+// public key of RS256 key as HS256 secret is rejected.
+func (verifier *TokenVerifier) verifyingKey(token *jwt.Token) (interface{}, error) {
+	var key Key
+	var err error
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		key, err = verifier.keys.SigningKey()
+		if err == ErrNoSigningKey {
+			return nil, ErrKeyNotFound
+		}
+	} else {
+		var ok bool
+		key, ok, err = verifier.keys.VerifyingKey(kid)
+		if err == nil && !ok {
+			return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, kid)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.alg() {
+		return nil, fmt.Errorf("%w: unexpected signing method %v", ErrInvalidSignature, token.Header["alg"])
+	}
+	return key.verifyingKey()
+}
+
+// RevokeToken revokes token so that it is rejected by AuthenticateToken until it expires.
+// Expired token is not revoked, and error is returned for invalid one.
+func (issuer *TokenIssuer) RevokeToken(tokenString string) error {
+	c, err := issuer.parse(tokenString)
+	if errors.Is(err, TokenExpiredError{}) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if c.ID == "" {
+		return errors.New("token has no id")
+	}
+	return issuer.revocations.RevokeToken(c.ID, c.ExpiresAt)
+}
+
+// PublicKeys returns public keys of the issuer as JSON Web Key Set, to be verified by TokenVerifier of other servers
+// with RemoteKeySet. Secret keys of HS256 are not published, so the set is empty if only those are used.
+func (issuer *TokenIssuer) PublicKeys() ([]byte, error) {
+	keys, err := issuer.keys.Keys()
+	if err != nil {
+		return nil, err
+	}
+	return MarshalJWKS(keys)
 }
 
-// GetIDFromToken extract id claim from JWT token
+// RevokeSession revokes every token of login session of sessionID, logging the user out of the device.
+func (issuer *TokenIssuer) RevokeSession(sessionID string) error {
+	return issuer.revocations.RevokeToken(sessionID, time.Now().Add(issuer.expireTime))
+}
+
+// RevokeUser revokes every token of user id issued until now, logging the user out of all devices.
+func (issuer *TokenIssuer) RevokeUser(id string) error {
+	now := time.Now()
+	return issuer.revocations.RevokeUser(id, now, now.Add(issuer.expireTime))
+}
+
+// GetIDFromToken extract id of the user from JWT token, without verifying it.
 func GetIDFromToken(tokenString string) (string, error) {
-	token, err := jwt.Parse(tokenString, nil)
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		return claims["id"].(string), nil
+	var c Claims
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenString, &c); err != nil {
+		return "", err
+	}
+	if c.Subject == "" {
+		return "", ErrTokenMissingClaim
+	}
+	return c.Subject, nil
+}
+
+// IsJWT reports whether token is in form of JWT, as opposed to opaque token like session id.
+func IsJWT(tokenString string) bool {
+	return strings.Count(tokenString, ".") == 2
+}
+
+// TokenExpired reports whether token is expired or has no valid expiration time, without verifying signature.
+// It is for clients holding tokens to tell when to refresh them. Tokens which are not JWT, like session ids,
+// are never reported expired since only their issuer knows when they expire.
+func TokenExpired(tokenString string) bool {
+	if !IsJWT(tokenString) {
+		return false
+	}
+	var c Claims
+	if _, _, err := new(jwt.Parser).ParseUnverified(tokenString, &c); err != nil {
+		return true
 	}
-	return "", err
+	return c.ExpiresAt.IsZero() || !time.Now().Before(c.ExpiresAt)
 }
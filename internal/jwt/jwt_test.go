@@ -1,8 +1,22 @@
 package jwt
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/dgrijalva/jwt-go"
 )
 
 func TestAuthenticate(t *testing.T) {
@@ -38,4 +52,473 @@ func TestExpiredKey(t *testing.T) {
 	} else {
 		t.Fail()
 	}
+	if !errors.Is(err, TokenExpiredError{}) {
+		t.Errorf("got %v, want TokenExpiredError", err)
+	}
+}
+
+// signClaims signs c with HS256 key "valid" like NewTokenIssuer("valid", ...).
+func signClaims(t *testing.T, c *Claims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte("valid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestClaims(t *testing.T) {
+	issuer := NewTokenIssuer("valid", time.Hour)
+	issuer.SetIssuer("backend", "web")
+	c, err := issuer.ParseToken(issuer.GenerateToken("id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Issuer != "backend" || c.Subject != "id" || !reflect.DeepEqual(c.Audience, []string{"web"}) || c.ID == "" ||
+		!c.NotBefore.Equal(c.IssuedAt) || !c.ExpiresAt.After(c.IssuedAt) {
+		t.Errorf("got claims %+v", c)
+	}
+
+	now := time.Now()
+	valid := Claims{Issuer: "backend", Subject: "id", Audience: []string{"web"}, IssuedAt: now, NotBefore: now, ExpiresAt: now.Add(time.Hour)}
+	tests := []struct {
+		name   string
+		modify func(c *Claims)
+		want   error
+	}{
+		{"valid", func(c *Claims) {}, nil},
+		{"expired", func(c *Claims) { c.ExpiresAt = now.Add(-time.Second) }, TokenExpiredError{}},
+		{"no expiration", func(c *Claims) { c.ExpiresAt = time.Time{} }, ErrTokenMissingClaim},
+		{"not before", func(c *Claims) { c.NotBefore = now.Add(time.Minute) }, ErrTokenNotValidYet},
+		{"issued in future", func(c *Claims) { c.IssuedAt = now.Add(time.Minute) }, ErrTokenNotValidYet},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "other" }, ErrInvalidIssuer},
+		{"wrong audience", func(c *Claims) { c.Audience = []string{"other"} }, ErrInvalidAudience},
+		{"no audience", func(c *Claims) { c.Audience = nil }, ErrInvalidAudience},
+		{"no subject", func(c *Claims) { c.Subject = "" }, ErrTokenMissingClaim},
+		// within leeway
+		{"just expired", func(c *Claims) { c.ExpiresAt = now.Add(-time.Second) }, nil},
+		{"clock behind", func(c *Claims) { c.IssuedAt, c.NotBefore = now.Add(time.Second), now.Add(time.Second) }, nil},
+	}
+	verifier := NewTokenVerifier(NewStaticKeySet(Key{Secret: "valid"}))
+	verifier.ExpectClaims("backend", "web")
+	for i, tt := range tests {
+		if tt.name == "just expired" {
+			verifier.SetLeeway(5 * time.Second)
+		}
+		c := valid
+		tt.modify(&c)
+		id, err := verifier.AuthenticateToken(signClaims(t, &c))
+		if err != tt.want {
+			t.Errorf("%d %s: got %v, want %v", i, tt.name, err, tt.want)
+		}
+		if err == nil && id != "id" {
+			t.Errorf("%d %s: got id %q", i, tt.name, id)
+		}
+	}
+}
+
+func TestClaimsErrors(t *testing.T) {
+	issuer := NewTokenIssuer("valid", time.Hour)
+	token := issuer.GenerateToken("id")
+	if _, err := NewTokenIssuer("invalid", time.Hour).AuthenticateToken(token); err != ErrInvalidSignature {
+		t.Errorf("got %v, want ErrInvalidSignature", err)
+	}
+	if _, err := issuer.AuthenticateToken("broken"); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("got %v, want ErrMalformedToken", err)
+	}
+	// token issued before sub claim carries id of the user in id claim
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id": "id", "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("valid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := issuer.AuthenticateToken(legacy); id != "id" || err != nil {
+		t.Errorf("legacy token: got %q, %v", id, err)
+	}
+	if id, err := GetIDFromToken(legacy); id != "id" || err != nil {
+		t.Errorf("legacy token: got %q, %v", id, err)
+	}
+}
+
+func TestClaimsJSON(t *testing.T) {
+	var c Claims
+	if err := json.Unmarshal([]byte(`{"aud":"web","sub":"id","exp":1.5}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Audience, []string{"web"}) || c.ExpiresAt.UnixNano() != 1500000000 {
+		t.Errorf("got %+v", c)
+	}
+	data, err := json.Marshal(Claims{Subject: "id", Audience: []string{"web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"sub":"id","aud":"web","id":"id"}` {
+		t.Errorf("got %s", data)
+	}
+	if err := json.Unmarshal([]byte(`{"aud":1}`), &c); err == nil {
+		t.Error("aud of number should be rejected")
+	}
+}
+
+func TestParseTokenIssuedAt(t *testing.T) {
+	issuer := NewTokenIssuer("valid", time.Hour)
+	before := time.Now()
+	token := issuer.GenerateToken("id")
+	after := time.Now()
+	c, err := issuer.ParseToken(token)
+	if err != nil || c.Subject != "id" {
+		t.Fatalf("got %+v, %v", c, err)
+	}
+	// issue time keeps sub-second precision, up to float64 rounding
+	iat := c.IssuedAt
+	if iat.Before(before.Add(-time.Millisecond)) || iat.After(after.Add(time.Millisecond)) {
+		t.Errorf("issue time %v is not between %v and %v", iat, before, after)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	issuer := NewTokenIssuer("valid", time.Hour)
+	token := issuer.GenerateToken("id")
+	other := issuer.GenerateToken("id")
+	if token == other {
+		t.Fatal("tokens should have unique id")
+	}
+	if err := issuer.RevokeToken(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.AuthenticateToken(token); err != ErrTokenRevoked {
+		t.Errorf("revoked token: got %v, want ErrTokenRevoked", err)
+	}
+	if id, err := issuer.AuthenticateToken(other); id != "id" || err != nil {
+		t.Errorf("other token: got %q, %v", id, err)
+	}
+	// token of another issuer can't be revoked
+	if err := NewTokenIssuer("invalid", time.Hour).RevokeToken(other); err == nil {
+		t.Error("token with wrong signature should not be revoked")
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	issuer := NewTokenIssuer("valid", time.Hour)
+	tokens := []string{issuer.GenerateSessionToken("id", "s1"), issuer.GenerateSessionToken("id", "s1")}
+	other := issuer.GenerateSessionToken("id", "s2")
+	if c, err := issuer.ParseToken(tokens[0]); err != nil || c.SessionID != "s1" {
+		t.Fatalf("got %+v, %v", c, err)
+	}
+	if err := issuer.RevokeSession("s1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if _, err := issuer.AuthenticateToken(token); err != ErrTokenRevoked {
+			t.Errorf("got %v, want ErrTokenRevoked", err)
+		}
+	}
+	if _, err := issuer.AuthenticateToken(other); err != nil {
+		t.Errorf("token of other session: %v", err)
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	issuer := NewTokenIssuer("valid", time.Hour)
+	tokens := []string{issuer.GenerateToken("id"), issuer.GenerateToken("id")}
+	untouched := issuer.GenerateToken("other")
+	if err := issuer.RevokeUser("id"); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if _, err := issuer.AuthenticateToken(token); err != ErrTokenRevoked {
+			t.Errorf("got %v, want ErrTokenRevoked", err)
+		}
+	}
+	if _, err := issuer.AuthenticateToken(untouched); err != nil {
+		t.Errorf("token of other user: %v", err)
+	}
+	if _, err := issuer.AuthenticateToken(issuer.GenerateToken("id")); err != nil {
+		t.Errorf("token issued after revocation: %v", err)
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	if TokenExpired(NewTokenIssuer("valid", time.Hour).GenerateToken("id")) {
+		t.Error("valid token is expired")
+	}
+	if !TokenExpired(NewTokenIssuer("valid", -time.Second).GenerateToken("id")) {
+		t.Error("token should be expired")
+	}
+	if !TokenExpired("broken.token.x") {
+		t.Error("broken token should be expired")
+	}
+	if TokenExpired("0123456789abcdef") {
+		t.Error("session id can't be told expired")
+	}
+}
+
+// kid returns kid header of token.
+func kid(t *testing.T, tokenString string) string {
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := token.Header["kid"].(string)
+	return id
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, current := Key{ID: "old", Secret: "secret1"}, Key{ID: "new", Secret: "secret2"}
+	before := NewKeySetTokenIssuer(NewStaticKeySet(old), time.Hour)
+	token := before.GenerateToken("id")
+	if got := kid(t, token); got != "old" {
+		t.Errorf("got kid %q, want old", got)
+	}
+
+	// token signed by previous key stays valid while the key is kept for verification
+	after := NewKeySetTokenIssuer(NewStaticKeySet(current, old), time.Hour)
+	if id, err := after.AuthenticateToken(token); id != "id" || err != nil {
+		t.Errorf("token of verify-only key: got %q, %v", id, err)
+	}
+	if got := kid(t, after.GenerateToken("id")); got != "new" {
+		t.Errorf("got kid %q, want new", got)
+	}
+	// and is rejected once the key is removed
+	removed := NewKeySetTokenIssuer(NewStaticKeySet(current), time.Hour)
+	if _, err := removed.AuthenticateToken(token); err == nil {
+		t.Error("token of removed key should be rejected")
+	}
+	// key of same id but other secret does not verify the token
+	forged := NewKeySetTokenIssuer(NewStaticKeySet(Key{ID: "old", Secret: "guess"}), time.Hour)
+	if _, err := after.AuthenticateToken(forged.GenerateToken("id")); err == nil {
+		t.Error("token of forged key should be rejected")
+	}
+}
+
+func TestLegacyTokenWithoutKid(t *testing.T) {
+	token := NewTokenIssuer("secret", time.Hour).GenerateToken("id")
+	if got := kid(t, token); got != "" {
+		t.Errorf("got kid %q", got)
+	}
+	issuer := NewKeySetTokenIssuer(NewStaticKeySet(Key{ID: "k1", Secret: "secret"}), time.Hour)
+	if id, err := issuer.AuthenticateToken(token); id != "id" || err != nil {
+		t.Errorf("got %q, %v", id, err)
+	}
+}
+
+func writeKeyFile(t *testing.T, path, content string, modTime time.Time) {
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, modTime, modTime)
+}
+
+func TestKeyFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.yaml")
+	now := time.Now()
+	writeKeyFile(t, path, "active: k1\nkeys:\n  - id: k1\n    secret: secret1\n", now)
+	keys, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := NewKeySetTokenIssuer(keys, time.Hour)
+	token := issuer.GenerateToken("id")
+
+	// rotate: k2 signs new tokens, k1 still verifies old ones
+	writeKeyFile(t, path, "active: k2\nkeys:\n  - id: k1\n    secret: secret1\n  - id: k2\n    secret: secret2\n", now.Add(time.Minute))
+	keys.checked = time.Time{}
+	if got := kid(t, issuer.GenerateToken("id")); got != "k2" {
+		t.Errorf("got kid %q after rotation, want k2", got)
+	}
+	if _, err = issuer.AuthenticateToken(token); err != nil {
+		t.Errorf("token of previous key: %v", err)
+	}
+
+	// broken file keeps keys loaded before
+	writeKeyFile(t, path, "active: k3\nkeys: []\n", now.Add(2*time.Minute))
+	keys.checked = time.Time{}
+	if got := kid(t, issuer.GenerateToken("id")); got != "k2" {
+		t.Errorf("got kid %q after broken reload, want k2", got)
+	}
+	if err = keys.Err(); err == nil || !strings.Contains(err.Error(), "k3") {
+		t.Errorf("got error %v of broken reload", err)
+	}
+}
+
+func TestParseKeyFile(t *testing.T) {
+	invalid := []string{
+		"active: k1\nkeys:\n  - id: k2\n    secret: s\n",
+		"active: k1\nkeys:\n  - id: k1\n    secret: s\n  - id: k1\n    secret: t\n",
+		"active: k1\nkeys:\n  - id: k1\n",
+		"active: k1\nkeys:\n  - id: k1\n    secret: s\n    extra: x\n",
+	}
+	for _, content := range invalid {
+		if _, err := parseKeyFile([]byte(content)); err == nil {
+			t.Errorf("key file %q should be rejected", content)
+		}
+	}
+}
+
+func asymmetricKeys(t *testing.T) []Key {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []Key{
+		{ID: "rsa", Algorithm: AlgRS256, PrivateKey: rsaKey},
+		{ID: "ed", Algorithm: AlgEdDSA, PrivateKey: edKey},
+	}
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	for _, key := range asymmetricKeys(t) {
+		issuer := NewKeySetTokenIssuer(NewStaticKeySet(key), time.Hour)
+		token := issuer.GenerateToken("id")
+		if token == "" {
+			t.Fatalf("%s: no token generated", key.Algorithm)
+		}
+		if id, err := issuer.AuthenticateToken(token); id != "id" || err != nil {
+			t.Errorf("%s: got %q, %v", key.Algorithm, id, err)
+		}
+		// verified with public key only
+		public := Key{ID: key.ID, Algorithm: key.Algorithm, PublicKey: key.public()}
+		verifier := NewTokenVerifier(NewStaticKeySet(public))
+		if id, err := verifier.AuthenticateToken(token); id != "id" || err != nil {
+			t.Errorf("%s: got %q, %v with public key", key.Algorithm, id, err)
+		}
+		if NewKeySetTokenIssuer(NewStaticKeySet(public), time.Hour).GenerateToken("id") != "" {
+			t.Errorf("%s: token generated with public key", key.Algorithm)
+		}
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	key := asymmetricKeys(t)[0]
+	der, err := x509.MarshalPKIXPublicKey(key.public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// public key is known to anyone, it must not be accepted as HMAC secret
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": "admin", "iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix()})
+	forged.Header["kid"] = key.ID
+	token, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewTokenVerifier(NewStaticKeySet(key))
+	if id, err := verifier.AuthenticateToken(token); err == nil {
+		t.Errorf("forged HS256 token accepted as %q", id)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	keys := asymmetricKeys(t)
+	issuer := NewKeySetTokenIssuer(NewStaticKeySet(keys[0], keys[1], Key{ID: "hmac", Secret: "secret"}), time.Hour)
+	data, err := issuer.PublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "hmac") {
+		t.Errorf("HS256 key published: %s", data)
+	}
+	published, err := ParseJWKS(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 2 {
+		t.Fatalf("got %d keys, want 2", len(published))
+	}
+	for _, k := range published {
+		if k.PrivateKey != nil {
+			t.Errorf("private key of %q published", k.ID)
+		}
+	}
+
+	fetches := 0
+	remote := NewRemoteKeySet(func() ([]byte, error) {
+		fetches++
+		return data, nil
+	}, time.Hour)
+	verifier := NewTokenVerifier(remote)
+	for _, key := range keys {
+		token := NewKeySetTokenIssuer(NewStaticKeySet(key), time.Hour).GenerateToken("id")
+		if id, err := verifier.AuthenticateToken(token); id != "id" || err != nil {
+			t.Errorf("%s: got %q, %v", key.Algorithm, id, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("keys fetched %d times, want 1", fetches)
+	}
+	// tokens of keys not published are left to the issuer, unknown key is fetched again at most once per interval
+	remote.fetched = time.Now().Add(-minKeyFetchInterval)
+	for _, token := range []string{
+		NewKeySetTokenIssuer(NewStaticKeySet(Key{ID: "hmac", Secret: "secret"}), time.Hour).GenerateToken("id"),
+		NewKeySetTokenIssuer(NewStaticKeySet(Key{ID: "hmac", Secret: "secret"}), time.Hour).GenerateToken("id"),
+		NewTokenIssuer("secret", time.Hour).GenerateToken("id"),
+	} {
+		if _, err := verifier.AuthenticateToken(token); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("got %v, want ErrKeyNotFound", err)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("keys fetched %d times for unknown key, want 2", fetches)
+	}
+}
+
+func TestRemoteKeySetFetchError(t *testing.T) {
+	keys := asymmetricKeys(t)
+	data, err := MarshalJWKS(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetchErr := errors.New("backend down")
+	remote := NewRemoteKeySet(func() ([]byte, error) {
+		return data, fetchErr
+	}, time.Hour)
+	if _, ok, err := remote.VerifyingKey("rsa"); ok || err != nil {
+		t.Errorf("got %v, %v before keys are fetched", ok, err)
+	}
+	if remote.Err() != fetchErr {
+		t.Errorf("got error %v", remote.Err())
+	}
+	// keys fetched before are kept when fetching fails
+	fetchErr = nil
+	remote.fetched = time.Time{}
+	remote.VerifyingKey("rsa")
+	fetchErr = errors.New("backend down")
+	remote.fetched = time.Time{}
+	if _, ok, _ := remote.VerifyingKey("rsa"); !ok {
+		t.Error("keys fetched before are lost")
+	}
+}
+
+func TestKeyFileAsymmetric(t *testing.T) {
+	key := asymmetricKeys(t)[1]
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indent := func(b []byte) string {
+		return strings.Replace(strings.TrimSpace(string(b)), "\n", "\n      ", -1)
+	}
+	content := "active: ed\nkeys:\n  - id: ed\n    alg: EdDSA\n    private_key: |\n      " +
+		indent(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})) + "\n"
+	keys, err := parseKeyFile([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := NewKeySetTokenIssuer(keys, time.Hour)
+	verifier := NewTokenVerifier(NewStaticKeySet(Key{ID: "ed", Algorithm: AlgEdDSA, PublicKey: key.public()}))
+	if id, err := verifier.AuthenticateToken(issuer.GenerateToken("id")); id != "id" || err != nil {
+		t.Errorf("got %q, %v", id, err)
+	}
+	// key of other algorithm than alg is rejected
+	if _, err = parseKeyFile([]byte(strings.Replace(content, "EdDSA", "RS256", 1))); err == nil {
+		t.Error("Ed25519 key of RS256 accepted")
+	}
 }
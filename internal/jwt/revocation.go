@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationList keeps revoked tokens until they expire.
+type RevocationList interface {
+	// RevokeToken revokes token of jti which expires at expires.
+	RevokeToken(jti string, expires time.Time) error
+	// TokenRevoked reports whether token of jti is revoked.
+	TokenRevoked(jti string) (bool, error)
+	// RevokeUser revokes tokens of user id issued until at, which all expire by expires.
+	RevokeUser(id string, at, expires time.Time) error
+	// UserRevokedAt returns the last time tokens of user id were revoked, zero time if they never were.
+	UserRevokedAt(id string) (time.Time, error)
+}
+
+// memoryRevocationList is RevocationList of a single server, revocations are lost on restart.
+type memoryRevocationList struct {
+	mutex  sync.RWMutex
+	tokens map[string]time.Time      // expiration time by jti
+	users  map[string]userRevocation // by user id
+}
+
+type userRevocation struct {
+	at, expires time.Time
+}
+
+// NewMemoryRevocationList create RevocationList in memory.
+func NewMemoryRevocationList() RevocationList {
+	return &memoryRevocationList{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]userRevocation),
+	}
+}
+
+func (l *memoryRevocationList) RevokeToken(jti string, expires time.Time) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.prune(time.Now())
+	l.tokens[jti] = expires
+	return nil
+}
+
+func (l *memoryRevocationList) TokenRevoked(jti string) (bool, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	_, ok := l.tokens[jti]
+	return ok, nil
+}
+
+func (l *memoryRevocationList) RevokeUser(id string, at, expires time.Time) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.prune(time.Now())
+	l.users[id] = userRevocation{at: at, expires: expires}
+	return nil
+}
+
+func (l *memoryRevocationList) UserRevokedAt(id string) (time.Time, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.users[id].at, nil
+}
+
+// prune removes revocations of tokens expired at now.
+func (l *memoryRevocationList) prune(now time.Time) {
+	for jti, expires := range l.tokens {
+		if now.After(expires) {
+			delete(l.tokens, jti)
+		}
+	}
+	for id, r := range l.users {
+		if now.After(r.expires) {
+			delete(l.users, id)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore is UserStore keeping users in memory, for tests and local development.
+type MemoryStore struct {
+	hasher PasswordHasher
+	mutex  sync.RWMutex
+	users  map[string]User
+}
+
+// NewMemoryStore create empty UserStore in memory hashing passwords by hasher.
+func NewMemoryStore(hasher PasswordHasher) *MemoryStore {
+	return &MemoryStore{hasher: hasher, users: make(map[string]User)}
+}
+
+// GetUser returns copy of the user, nil if there is no such user.
+func (s *MemoryStore) GetUser(ctx context.Context, id string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+// UpdateUser updates non-empty fields of the user, nothing is done if there is no such user like UPDATE in DB.
+func (s *MemoryStore) UpdateUser(ctx context.Context, user *User) error {
+	var hash string
+	if user.Password != "" {
+		var err error
+		if hash, err = s.hasher.Hash(user.Password); err != nil {
+			return err
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	old, ok := s.users[user.Id]
+	if !ok {
+		return nil
+	}
+	if user.Password != "" {
+		old.Password = hash
+	}
+	if user.Nickname != "" {
+		old.Nickname = user.Nickname
+	}
+	if user.PicPath != "" {
+		old.PicPath = user.PicPath
+	}
+	s.users[user.Id] = old
+	return nil
+}
+
+// Authenticate compares password with the user's, and rehashes it if outdated.
+func (s *MemoryStore) Authenticate(ctx context.Context, id, password string) (bool, error) {
+	user, _ := s.GetUser(ctx, id)
+	if user == nil {
+		return false, nil
+	}
+	ok, rehash := s.hasher.Verify(user.Password, password)
+	if ok && rehash {
+		hash, err := s.hasher.Hash(password)
+		if err != nil {
+			return true, nil // old hash still works, rehashed on next login
+		}
+		s.mutex.Lock()
+		// password may have been changed meanwhile
+		if current, found := s.users[id]; found && current.Password == user.Password {
+			current.Password = hash
+			s.users[id] = current
+		}
+		s.mutex.Unlock()
+	}
+	return ok, nil
+}
+
+// CreateUser adds copy of the user.
+func (s *MemoryStore) CreateUser(ctx context.Context, user *User) error {
+	hash, err := s.hasher.Hash(user.Password)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.users[user.Id]; ok {
+		return ErrUserExists
+	}
+	created := *user
+	created.Password = hash
+	s.users[user.Id] = created
+	return nil
+}
+
+// DeleteUser removes the user.
+func (s *MemoryStore) DeleteUser(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
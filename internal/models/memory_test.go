@@ -0,0 +1,47 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(NewBcryptHasher(bcrypt.MinCost))
+	if err := store.CreateUser(ctx, &User{Id: "song", Password: "passwd", Nickname: "young"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateUser(ctx, &User{Id: "song"}); err != ErrUserExists {
+		t.Errorf("duplicate id: got %v, want ErrUserExists", err)
+	}
+	if ok, _ := store.Authenticate(ctx, "song", "passwd"); !ok {
+		t.Error("valid password is rejected")
+	}
+	if ok, _ := store.Authenticate(ctx, "song", "wrong"); ok {
+		t.Error("wrong password is accepted")
+	}
+
+	// only non-empty fields are updated
+	store.UpdateUser(ctx, &User{Id: "song", PicPath: "pic.png"})
+	user, _ := store.GetUser(ctx, "song")
+	if user.Nickname != "young" || user.PicPath != "pic.png" || user.Password == "passwd" {
+		t.Errorf("got %+v", user)
+	}
+	// returned user is a copy
+	user.Nickname = "changed"
+	if user, _ = store.GetUser(ctx, "song"); user.Nickname != "young" {
+		t.Error("store is modified through returned user")
+	}
+
+	if err := store.DeleteUser(ctx, "song"); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := store.GetUser(ctx, "song"); user != nil || err != nil {
+		t.Errorf("deleted user: got %v, %v", user, err)
+	}
+	if err := store.DeleteUser(ctx, "song"); err != ErrUserNotFound {
+		t.Errorf("got %v, want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// errDuplicateEntry is number of MySQL error of insert violating unique key.
+const errDuplicateEntry = 1062
+
+// Statements on USER table, values are always passed as parameters.
+// Update sets only the fields given as non-NULL parameters.
+const (
+	selectUserQuery = "SELECT id, password, nickname, pic_path FROM USER WHERE id = ?"
+	updateUserQuery = "UPDATE USER SET password = COALESCE(?, password), nickname = COALESCE(?, nickname), pic_path = COALESCE(?, pic_path) WHERE id = ?"
+	insertUserQuery = "INSERT INTO USER (id, password, nickname, pic_path) VALUES (?, ?, ?, ?)"
+	deleteUserQuery = "DELETE FROM USER WHERE id = ?"
+	// replaces password hash only if it has not been changed since read
+	rehashUserQuery = "UPDATE USER SET password = ? WHERE id = ? AND password = ?"
+)
+
+// MySQLStore is UserStore on USER table of MySQL database.
+type MySQLStore struct {
+	db                                                         *sql.DB
+	hasher                                                     PasswordHasher
+	selectUser, updateUser, insertUser, deleteUser, rehashUser *sql.Stmt // prepared once by NewMySQLStore
+}
+
+// NewMySQLStore create UserStore using db, preparing statements it uses. Passwords are hashed by hasher.
+func NewMySQLStore(db *sql.DB, hasher PasswordHasher) (*MySQLStore, error) {
+	s := &MySQLStore{db: db, hasher: hasher}
+	stmts := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&s.selectUser, selectUserQuery},
+		{&s.updateUser, updateUserQuery},
+		{&s.insertUser, insertUserQuery},
+		{&s.deleteUser, deleteUserQuery},
+		{&s.rehashUser, rehashUserQuery},
+	}
+	for _, st := range stmts {
+		stmt, err := db.Prepare(st.query)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		*st.stmt = stmt
+	}
+	return s, nil
+}
+
+// Close closes prepared statements, db is not closed.
+func (s *MySQLStore) Close() error {
+	for _, stmt := range []*sql.Stmt{s.selectUser, s.updateUser, s.insertUser, s.deleteUser, s.rehashUser} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return nil
+}
+
+// nullIfEmpty makes empty field NULL so that update keeps the column.
+func nullIfEmpty(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
+// GetUser fetch user information from DB
+func (s *MySQLStore) GetUser(ctx context.Context, id string) (*User, error) {
+	var user User
+	err := s.selectUser.QueryRowContext(ctx, id).Scan(&user.Id, &user.Password, &user.Nickname, &user.PicPath)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser Update User's information in DB, empty field's are not updated
+func (s *MySQLStore) UpdateUser(ctx context.Context, user *User) error {
+	var password string
+	if user.Password != "" {
+		var err error
+		if password, err = s.hasher.Hash(user.Password); err != nil {
+			return err
+		}
+	}
+	_, err := s.updateUser.ExecContext(ctx, nullIfEmpty(password), nullIfEmpty(user.Nickname), nullIfEmpty(user.PicPath), user.Id)
+	return err
+}
+
+// Authenticate fetch user's information by calling GetUser from DB, compare it with password.
+// Outdated hash, like legacy MD5, is replaced with new hash of the password.
+func (s *MySQLStore) Authenticate(ctx context.Context, id, password string) (bool, error) {
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, nil
+	}
+	ok, rehash := s.hasher.Verify(user.Password, password)
+	if ok && rehash {
+		// on failure old hash still works, and it is rehashed on next login
+		if hash, err := s.hasher.Hash(password); err == nil {
+			s.rehashUser.ExecContext(ctx, hash, id, user.Password)
+		}
+	}
+	return ok, nil
+}
+
+// CreateUser insert new user to DB. Existing id is detected by primary key of the table rather than read before,
+// so that only one of concurrent signups of an id succeeds and others get ErrUserExists.
+func (s *MySQLStore) CreateUser(ctx context.Context, user *User) error {
+	hash, err := s.hasher.Hash(user.Password)
+	if err != nil {
+		return err
+	}
+	_, err = s.insertUser.ExecContext(ctx, user.Id, hash, user.Nickname, user.PicPath)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == errDuplicateEntry {
+		return ErrUserExists
+	}
+	return err
+}
+
+// DeleteUser delete user from DB.
+func (s *MySQLStore) DeleteUser(ctx context.Context, id string) error {
+	res, err := s.deleteUser.ExecContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeDB is a database/sql driver executing statements of MySQLStore on a map, recording every query.
+// It fails on any query with a value in it instead of a parameter.
+type fakeDB struct {
+	mutex   sync.Mutex
+	users   map[string][]driver.Value // id, password, nickname, pic_path
+	queries []string
+}
+
+func (d *fakeDB) Open(name string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	switch query {
+	case selectUserQuery, updateUserQuery, insertUserQuery, deleteUserQuery, rehashUserQuery:
+	default:
+		return nil, errors.New("unexpected query: " + query)
+	}
+	c.db.mutex.Lock()
+	c.db.queries = append(c.db.queries, query)
+	c.db.mutex.Unlock()
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+	id := args[len(args)-1].(string)
+	switch s.query {
+	case insertUserQuery:
+		id = args[0].(string)
+		// id is the primary key
+		if _, ok := s.db.users[id]; ok {
+			return nil, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '" + id + "' for key 'PRIMARY'"}
+		}
+		s.db.users[id] = args
+	case updateUserQuery:
+		row, ok := s.db.users[id]
+		if !ok {
+			return driver.RowsAffected(0), nil
+		}
+		// COALESCE keeps columns of NULL parameters
+		for i, v := range args[:3] {
+			if v != nil {
+				row[i+1] = v
+			}
+		}
+	case rehashUserQuery:
+		id = args[1].(string)
+		row, ok := s.db.users[id]
+		if !ok || row[1] != args[2] {
+			return driver.RowsAffected(0), nil
+		}
+		row[1] = args[0]
+	case deleteUserQuery:
+		if _, ok := s.db.users[id]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		delete(s.db.users, id)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mutex.Lock()
+	defer s.db.mutex.Unlock()
+	row, ok := s.db.users[args[0].(string)]
+	if !ok {
+		return &fakeRows{}, nil
+	}
+	return &fakeRows{rows: [][]driver.Value{append([]driver.Value(nil), row...)}}, nil
+}
+
+type fakeRows struct{ rows [][]driver.Value }
+
+func (r *fakeRows) Columns() []string { return []string{"id", "password", "nickname", "pic_path"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var fake = &fakeDB{users: make(map[string][]driver.Value)}
+
+func init() {
+	sql.Register("fakemysql", fake)
+}
+
+func newFakeStore(t *testing.T) *MySQLStore {
+	db, err := sql.Open("fakemysql", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewMySQLStore(db, NewBcryptHasher(bcrypt.MinCost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		db.Close()
+	})
+	return store
+}
+
+func TestMySQLStoreInjection(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore(t)
+	payloads := []string{
+		"x', nickname='hacked",
+		"'; DROP TABLE USER; --",
+		`\' OR 1=1 #`,
+	}
+	for _, payload := range payloads {
+		id := "id" + payload
+		if err := store.CreateUser(ctx, &User{Id: id, Password: "passwd", Nickname: payload}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateUser(ctx, &User{Id: id, PicPath: payload}); err != nil {
+			t.Fatal(err)
+		}
+		user, err := store.GetUser(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user == nil || user.Id != id || user.Nickname != payload || user.PicPath != payload {
+			t.Errorf("payload %q is not stored literally, got %+v", payload, user)
+		}
+		if ok, _ := store.Authenticate(ctx, id, "passwd"); !ok {
+			t.Errorf("user %q cannot log in", id)
+		}
+	}
+	for _, query := range fake.queries {
+		for _, payload := range payloads {
+			if strings.Contains(query, payload) {
+				t.Errorf("payload %q is in query %q", payload, query)
+			}
+		}
+	}
+}
+
+func TestMySQLStorePartialUpdate(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore(t)
+	store.CreateUser(ctx, &User{Id: "partial", Password: "passwd", Nickname: "young", PicPath: "a.png"})
+	updates := []struct {
+		update User
+		want   User
+	}{
+		{User{Nickname: "iek"}, User{Nickname: "iek", PicPath: "a.png"}},
+		{User{PicPath: "b.png"}, User{Nickname: "iek", PicPath: "b.png"}},
+		{User{Nickname: "song", PicPath: "c.png"}, User{Nickname: "song", PicPath: "c.png"}},
+		{User{}, User{Nickname: "song", PicPath: "c.png"}},
+	}
+	for _, u := range updates {
+		u.update.Id = "partial"
+		if err := store.UpdateUser(ctx, &u.update); err != nil {
+			t.Fatal(err)
+		}
+		user, _ := store.GetUser(ctx, "partial")
+		if user.Nickname != u.want.Nickname || user.PicPath != u.want.PicPath {
+			t.Errorf("after update %+v got %+v, want %+v", u.update, user, u.want)
+		}
+	}
+	// password is hashed, and kept unless given
+	if ok, _ := store.Authenticate(ctx, "partial", "passwd"); !ok {
+		t.Error("password should not be changed by partial update")
+	}
+	store.UpdateUser(ctx, &User{Id: "partial", Password: "new"})
+	if ok, _ := store.Authenticate(ctx, "partial", "new"); !ok {
+		t.Error("password is not updated")
+	}
+}
+
+func TestMySQLStoreMissingUser(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore(t)
+	if user, err := store.GetUser(ctx, "nobody"); user != nil || err != nil {
+		t.Errorf("got %v, %v", user, err)
+	}
+	if ok, err := store.Authenticate(ctx, "nobody", ""); ok || err != nil {
+		t.Errorf("got %v, %v", ok, err)
+	}
+	if err := store.DeleteUser(ctx, "nobody"); err != ErrUserNotFound {
+		t.Errorf("got %v, want ErrUserNotFound", err)
+	}
+	store.CreateUser(ctx, &User{Id: "dup"})
+	if err := store.CreateUser(ctx, &User{Id: "dup"}); err != ErrUserExists {
+		t.Errorf("got %v, want ErrUserExists", err)
+	}
+}
+
+func TestMySQLStoreConcurrentCreate(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore(t)
+	const signups = 8
+	errs := make(chan error, signups)
+	for i := 0; i < signups; i++ {
+		go func() {
+			errs <- store.CreateUser(ctx, &User{Id: "racer", Password: "passw0rd"})
+		}()
+	}
+	created := 0
+	for i := 0; i < signups; i++ {
+		switch err := <-errs; err {
+		case nil:
+			created++
+		case ErrUserExists:
+		default:
+			t.Errorf("got %v, want ErrUserExists", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d signups succeeded, want 1", created)
+	}
+}
+
+func TestMySQLStoreMigratesLegacyHash(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStore(t)
+	fake.mutex.Lock()
+	fake.users["legacy"] = []driver.Value{"legacy", legacyHash("passwd"), "", ""}
+	fake.mutex.Unlock()
+
+	if ok, _ := store.Authenticate(ctx, "legacy", "wrong"); ok {
+		t.Fatal("wrong password is accepted")
+	}
+	if user, _ := store.GetUser(ctx, "legacy"); user.Password != legacyHash("passwd") {
+		t.Error("hash should not be replaced on failed login")
+	}
+	if ok, _ := store.Authenticate(ctx, "legacy", "passwd"); !ok {
+		t.Fatal("legacy password is rejected")
+	}
+	user, _ := store.GetUser(ctx, "legacy")
+	if isLegacyHash(user.Password) {
+		t.Fatal("legacy hash is not replaced on login")
+	}
+	if ok, _ := store.Authenticate(ctx, "legacy", "passwd"); !ok {
+		t.Error("password is rejected after migration")
+	}
+}
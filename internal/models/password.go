@@ -0,0 +1,69 @@
+package models
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes passwords to be stored and verifies passwords against stored hashes.
+type PasswordHasher interface {
+	// Hash returns hash of password encoding it's salt and parameters.
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash, and whether hash is outdated and should be
+	// replaced by Hash of the password.
+	Verify(hash, password string) (ok, rehash bool)
+}
+
+// bcryptHasher hashes passwords with bcrypt, and verifies legacy MD5 hashes too.
+type bcryptHasher struct {
+	cost int
+}
+
+// NewBcryptHasher create PasswordHasher using bcrypt of cost, bcrypt.DefaultCost if cost is 0.
+// Legacy salted MD5 hashes are accepted by Verify and reported to be rehashed.
+func NewBcryptHasher(cost int) PasswordHasher {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	if cost < bcrypt.MinCost {
+		cost = bcrypt.MinCost
+	}
+	if cost > bcrypt.MaxCost {
+		cost = bcrypt.MaxCost
+	}
+	return bcryptHasher{cost: cost}
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h bcryptHasher) Verify(hash, password string) (ok, rehash bool) {
+	if isLegacyHash(hash) {
+		return subtle.ConstantTimeCompare([]byte(legacyHash(password)), []byte(hash)) == 1, true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || cost != h.cost
+}
+
+// legacyHash returns hash of password stored in user table before bcrypt, md5 with global salt.
+func legacyHash(password string) string {
+	hash := md5.Sum([]byte("salt#" + password))
+	return hex.EncodeToString(hash[:])
+}
+
+// isLegacyHash reports whether hash is created by legacyHash.
+func isLegacyHash(hash string) bool {
+	if len(hash) != 2*md5.Size {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
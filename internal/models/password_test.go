@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBcryptHasher(t *testing.T) {
+	hasher := NewBcryptHasher(bcrypt.MinCost)
+	hash, err := hasher.Hash("passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$2a$04$") {
+		t.Errorf("hash %q should encode algorithm and cost", hash)
+	}
+	// salt is random for each hash
+	if other, _ := hasher.Hash("passwd"); other == hash {
+		t.Error("same password should have different hashes")
+	}
+	if ok, rehash := hasher.Verify(hash, "passwd"); !ok || rehash {
+		t.Errorf("got ok %v, rehash %v", ok, rehash)
+	}
+	if ok, _ := hasher.Verify(hash, "wrong"); ok {
+		t.Error("wrong password is accepted")
+	}
+	// hash of other cost is outdated
+	if ok, rehash := NewBcryptHasher(bcrypt.MinCost+1).Verify(hash, "passwd"); !ok || !rehash {
+		t.Errorf("other cost: got ok %v, rehash %v", ok, rehash)
+	}
+}
+
+func TestBcryptHasherLegacy(t *testing.T) {
+	hasher := NewBcryptHasher(bcrypt.MinCost)
+	legacy := legacyHash("passwd")
+	if ok, rehash := hasher.Verify(legacy, "passwd"); !ok || !rehash {
+		t.Errorf("got ok %v, rehash %v", ok, rehash)
+	}
+	if ok, _ := hasher.Verify(legacy, "wrong"); ok {
+		t.Error("wrong password is accepted")
+	}
+	if ok, _ := hasher.Verify("not a hash", "not a hash"); ok {
+		t.Error("broken hash should not match")
+	}
+}
@@ -1,11 +1,8 @@
 package models
 
 import (
-	"bytes"
-	"crypto/md5"
-	"database/sql"
-	"encoding/hex"
-	"fmt"
+	"context"
+	"errors"
 )
 
 type User struct {
@@ -15,71 +12,25 @@ type User struct {
 	PicPath  string
 }
 
-// helper function to create statement for update in user table
-func (u *User) updateStatement() string {
-	var stmtStr bytes.Buffer
-	i := 0
-	if u.Password != "" {
-		stmtStr.WriteString("password=")
-		stmtStr.WriteString(fmt.Sprintf("'%s'", u.Password))
-		i++
-	}
-	if u.Nickname != "" {
-		if i > 1 {
-			stmtStr.WriteString(",")
-		}
-		stmtStr.WriteString("nickname=")
-		stmtStr.WriteString(fmt.Sprintf("'%s'", u.Nickname))
-		i++
-	}
-	if u.PicPath != "" {
-		if i > 1 {
-			stmtStr.WriteString(",")
-		}
-		stmtStr.WriteString("pic_path=")
-		stmtStr.WriteString(fmt.Sprintf("'%s'", u.PicPath))
-		i++
-	}
-	return stmtStr.String()
-}
-
-// GetUserById fetch user information from DB
-func GetUserById(db *sql.DB, id string) (*User, error) {
-	var user User
-	rows, err := db.Query(fmt.Sprintf("SELECT id, password, nickname, pic_path FROM USER WHERE id = '%s'", id))
-	if err != nil {
-		return nil, err
-	}
-	if !rows.Next() {
-		return nil, nil
-	}
-	err = rows.Scan(&user.Id, &user.Password, &user.Nickname, &user.PicPath)
-	if err != nil {
-		return nil, err
-	}
-	rows.Close()
-	return &user, nil
-}
-
-// SetUser Update User's information in DB, empty field's are not updated
-func SetUser(db *sql.DB, user *User) error {
-	res, err := db.Exec(fmt.Sprintf("UPDATE USER SET %s WHERE id='%s'", user.updateStatement(), user.Id))
-	if err != nil {
-		return err
-	}
-	_, err = res.RowsAffected()
-	return err
-}
+// Errors returned by UserStore.
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+)
 
-// Authenticate fetch user's information by calling GetUserInfo from DB, compare it with password.
-func Authenticate(db *sql.DB, id, password string) (bool, error) {
-	user, err := GetUserById(db, id)
-	if err != nil {
-		return false, err
-	}
-	if user == nil {
-		return false, nil
-	}
-	hash := md5.Sum([]byte("salt#" + password))
-	return hex.EncodeToString(hash[:]) == user.Password, nil
+// UserStore stores users and their passwords.
+// Passwords given to the store are plain text and hashed by the store's PasswordHasher, Password of User got from
+// the store is the hash.
+type UserStore interface {
+	// GetUser returns user of id, or nil if there is no such user.
+	GetUser(ctx context.Context, id string) (*User, error)
+	// UpdateUser updates user of user.Id, empty fields are not updated.
+	UpdateUser(ctx context.Context, user *User) error
+	// Authenticate reports whether password is the password of user id, false if there is no such user.
+	// Outdated hash of the password is replaced on success.
+	Authenticate(ctx context.Context, id, password string) (bool, error)
+	// CreateUser adds new user, ErrUserExists is returned if the id is taken.
+	CreateUser(ctx context.Context, user *User) error
+	// DeleteUser removes user of id, ErrUserNotFound is returned if there is no such user.
+	DeleteUser(ctx context.Context, id string) error
 }
@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier delivers messages to users, like password reset tokens.
+type Notifier interface {
+	// NotifyPasswordReset sends reset token of user id valid until expires.
+	NotifyPasswordReset(ctx context.Context, id, token string, expires time.Time) error
+}
+
+// FileNotifier writes notifications to a file instead of sending them, for local development.
+type FileNotifier struct {
+	mutex sync.Mutex
+	path  string
+	out   io.Writer // used instead of path if set
+}
+
+// NewFileNotifier create Notifier appending notifications to file of path, or writing them to stdout if path is empty.
+func NewFileNotifier(path string) *FileNotifier {
+	n := &FileNotifier{path: path}
+	if path == "" {
+		n.out = os.Stdout
+	}
+	return n
+}
+
+// NotifyPasswordReset writes a line with the token.
+func (n *FileNotifier) NotifyPasswordReset(ctx context.Context, id, token string, expires time.Time) error {
+	line := fmt.Sprintf("%s password reset for %s: token %s valid until %s\n",
+		time.Now().Format(time.RFC3339), id, token, expires.Format(time.RFC3339))
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.out != nil {
+		_, err := io.WriteString(n.out, line)
+		return err
+	}
+	f, err := os.OpenFile(n.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
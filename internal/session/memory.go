@@ -0,0 +1,61 @@
+package session
+
+import (
+	"context"
+	"time"
+)
+
+// MemoryStore is Manager keeping sessions in memory of a single server, sessions are lost on restart.
+// Session expires when not used for idle expiration time, which is extended on every authentication.
+// Session is kept under hash of it's token, which is the id of the session.
+type MemoryStore struct {
+	records *records
+}
+
+// NewMemoryStore create MemoryStore of sessions expiring after idle for expire, 30 minutes if 0.
+func NewMemoryStore(expire time.Duration) *MemoryStore {
+	if expire <= 0 {
+		expire = 30 * time.Minute
+	}
+	return &MemoryStore{records: newRecords(expire)}
+}
+
+var _ Manager = (*MemoryStore)(nil)
+
+func (s *MemoryStore) Issue(ctx context.Context, id string, device Device) (string, string, error) {
+	token, err := NewID()
+	if err != nil {
+		return "", "", err
+	}
+	sessionID := HashID(token)
+	return token, sessionID, s.records.Add(ctx, id, sessionID, device)
+}
+
+func (s *MemoryStore) Authenticate(ctx context.Context, token string) (string, string, error) {
+	sessionID := HashID(token)
+	id, err := s.records.Touch(ctx, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return id, sessionID, nil
+}
+
+func (s *MemoryStore) Revoke(ctx context.Context, token string) error {
+	sessionID := HashID(token)
+	if id, err := s.records.Owner(ctx, sessionID); err == nil {
+		return s.records.Remove(ctx, id, sessionID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeUser(ctx context.Context, id string) error {
+	return s.records.RemoveUser(ctx, id)
+}
+
+func (s *MemoryStore) List(ctx context.Context, id string) ([]Session, error) {
+	return s.records.List(ctx, id)
+}
+
+func (s *MemoryStore) RevokeSession(ctx context.Context, id, sessionID string) error {
+	return s.records.Remove(ctx, id, sessionID)
+}
@@ -0,0 +1,169 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+	ctx := context.Background()
+	token, sessionID, err := s.Issue(ctx, "song", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := s.Issue(ctx, "song", Device{})
+	if id, sid, err := s.Authenticate(ctx, token); id != "song" || sid != sessionID || err != nil {
+		t.Errorf("got %q, %q, %v", id, sid, err)
+	}
+	if _, _, err := s.Authenticate(ctx, "unknown"); err != ErrSessionNotFound {
+		t.Errorf("unknown session: got %v", err)
+	}
+	// revoking one session leaves the other
+	s.Revoke(ctx, token)
+	if _, _, err := s.Authenticate(ctx, token); err != ErrSessionNotFound {
+		t.Errorf("revoked session: got %v", err)
+	}
+	if _, _, err := s.Authenticate(ctx, other); err != nil {
+		t.Errorf("other session: %v", err)
+	}
+	s.RevokeUser(ctx, "song")
+	if _, _, err := s.Authenticate(ctx, other); err != ErrSessionNotFound {
+		t.Errorf("session of revoked user: got %v", err)
+	}
+}
+
+func TestMemoryStoreSlidingExpiration(t *testing.T) {
+	s := NewMemoryStore(50 * time.Millisecond)
+	ctx := context.Background()
+	token, _, _ := s.Issue(ctx, "song", Device{})
+	idle, _, _ := s.Issue(ctx, "song", Device{})
+	// used session is extended, idle one expires
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if _, _, err := s.Authenticate(ctx, token); err != nil {
+			t.Fatalf("used session expired: %v", err)
+		}
+	}
+	if _, _, err := s.Authenticate(ctx, idle); err != ErrSessionNotFound {
+		t.Errorf("idle session: got %v", err)
+	}
+	s.Issue(ctx, "other", Device{})
+	if _, ok := s.records.sessions[HashID(idle)]; ok {
+		t.Error("expired session is not pruned")
+	}
+}
+
+func TestMemoryStoreSessions(t *testing.T) {
+	s := NewMemoryStore(time.Hour)
+	ctx := context.Background()
+	_, phone, _ := s.Issue(ctx, "song", Device{UserAgent: "phone", RemoteIP: "10.0.0.1"})
+	laptopToken, laptop, _ := s.Issue(ctx, "song", Device{UserAgent: "laptop", RemoteIP: "10.0.0.2"})
+	s.Issue(ctx, "other", Device{UserAgent: "other"})
+	time.Sleep(time.Millisecond)
+	s.Authenticate(ctx, laptopToken)
+
+	sessions, err := s.List(ctx, "song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != laptop || sessions[1].ID != phone {
+		t.Fatalf("sessions: got %+v", sessions)
+	}
+	if sessions[1].UserAgent != "phone" || sessions[1].RemoteIP != "10.0.0.1" {
+		t.Errorf("device: got %+v", sessions[1].Device)
+	}
+	if !sessions[0].LastSeen.After(sessions[0].Created) {
+		t.Error("last seen is not updated on authentication")
+	}
+
+	// session of other user is not revoked
+	s.RevokeSession(ctx, "other", laptop)
+	if _, _, err := s.Authenticate(ctx, laptopToken); err != nil {
+		t.Errorf("session revoked by other user: %v", err)
+	}
+	s.RevokeSession(ctx, "song", laptop)
+	if _, _, err := s.Authenticate(ctx, laptopToken); err != ErrSessionNotFound {
+		t.Errorf("revoked session: got %v", err)
+	}
+	if sessions, _ := s.List(ctx, "song"); len(sessions) != 1 || sessions[0].ID != phone {
+		t.Errorf("sessions after revoke: got %+v", sessions)
+	}
+}
+
+func TestMemoryResetTokens(t *testing.T) {
+	r := NewMemoryResetTokens(time.Hour)
+	ctx := context.Background()
+	token, _, err := r.Issue(ctx, "song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := r.Use(ctx, token); id != "song" || err != nil {
+		t.Errorf("got %q, %v", id, err)
+	}
+	if _, err := r.Use(ctx, token); err != ErrResetTokenInvalid {
+		t.Errorf("used token: got %v, want ErrResetTokenInvalid", err)
+	}
+
+	// revoking the user revokes only it's tokens
+	token, _, _ = r.Issue(ctx, "song")
+	other, _, _ := r.Issue(ctx, "kim")
+	if err := r.RevokeUser(ctx, "song"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Use(ctx, token); err != ErrResetTokenInvalid {
+		t.Errorf("revoked token: got %v, want ErrResetTokenInvalid", err)
+	}
+	if id, err := r.Use(ctx, other); id != "kim" || err != nil {
+		t.Errorf("token of other user: got %q, %v", id, err)
+	}
+
+	r = NewMemoryResetTokens(time.Millisecond)
+	token, _, _ = r.Issue(ctx, "song")
+	time.Sleep(5 * time.Millisecond)
+	if _, err := r.Use(ctx, token); err != ErrResetTokenInvalid {
+		t.Errorf("expired token: got %v, want ErrResetTokenInvalid", err)
+	}
+}
+
+func TestMemoryRefreshTokens(t *testing.T) {
+	r := NewMemoryRefreshTokens(time.Hour, time.Hour)
+	ctx := context.Background()
+	token, err := r.Issue(ctx, "song", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, family, next, err := r.Rotate(ctx, token)
+	if id != "song" || family != "session" || next == "" || err != nil {
+		t.Fatalf("got %q, %q, %q, %v", id, family, next, err)
+	}
+	// used token is accepted again within grace time
+	if _, _, other, err := r.Rotate(ctx, token); other == "" || other == next || err != nil {
+		t.Errorf("used token within grace: got %q, %v", other, err)
+	}
+
+	// after grace time the family is revoked, including the token rotated from it
+	r = NewMemoryRefreshTokens(time.Hour, 0)
+	token, _ = r.Issue(ctx, "song", "session")
+	_, _, next, _ = r.Rotate(ctx, token)
+	time.Sleep(time.Millisecond)
+	if id, family, _, err := r.Rotate(ctx, token); id != "song" || family != "session" || err != ErrRefreshReused {
+		t.Errorf("reused token: got %q, %q, %v, want ErrRefreshReused", id, family, err)
+	}
+	if _, _, _, err := r.Rotate(ctx, next); err != ErrRefreshInvalid {
+		t.Errorf("token of revoked family: got %v, want ErrRefreshInvalid", err)
+	}
+
+	r = NewMemoryRefreshTokens(time.Millisecond, 0)
+	token, _ = r.Issue(ctx, "song", "session")
+	time.Sleep(5 * time.Millisecond)
+	if _, _, _, err := r.Rotate(ctx, token); err != ErrRefreshInvalid {
+		t.Errorf("expired token: got %v, want ErrRefreshInvalid", err)
+	}
+	// expired families are removed on next login
+	r.Issue(ctx, "song", "other")
+	if n := len(r.(*memoryRefreshTokens).families); n != 1 {
+		t.Errorf("got %d families, want 1", n)
+	}
+}
@@ -0,0 +1,132 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Records keeps sessions of users, to list them and to find the user of a session. Session expires when not seen
+// for a while.
+type Records interface {
+	// Add records new session of sessionID of user id on device.
+	Add(ctx context.Context, id, sessionID string, device Device) error
+	// Touch marks session seen now, extending it's expiration, and returns it's user.
+	// ErrSessionNotFound is returned for unknown or expired session.
+	Touch(ctx context.Context, sessionID string) (string, error)
+	// List returns sessions of user id, most recently seen first.
+	List(ctx context.Context, id string) ([]Session, error)
+	// Owner returns user of session, ErrSessionNotFound if it is unknown or expired.
+	Owner(ctx context.Context, sessionID string) (string, error)
+	// Remove removes session of sessionID of user id.
+	Remove(ctx context.Context, id, sessionID string) error
+	// RemoveUser removes every session of user id.
+	RemoveUser(ctx context.Context, id string) error
+}
+
+// records is Records in memory of a single server, sessions are lost on restart.
+type records struct {
+	mutex    sync.Mutex
+	expire   time.Duration
+	sessions map[string]*record // by session id
+}
+
+type record struct {
+	user    string
+	session Session
+	expires time.Time
+}
+
+// NewMemoryRecords create Records in memory, sessions expire when not seen for expire.
+func NewMemoryRecords(expire time.Duration) Records {
+	return newRecords(expire)
+}
+
+func newRecords(expire time.Duration) *records {
+	return &records{
+		expire:   expire,
+		sessions: make(map[string]*record),
+	}
+}
+
+func (r *records) Add(ctx context.Context, id, sessionID string, device Device) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	r.prune(now)
+	r.sessions[sessionID] = &record{
+		user:    id,
+		session: Session{ID: sessionID, Device: device, Created: now, LastSeen: now},
+		expires: now.Add(r.expire),
+	}
+	return nil
+}
+
+func (r *records) Touch(ctx context.Context, sessionID string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	rec, ok := r.sessions[sessionID]
+	if !ok || !now.Before(rec.expires) {
+		return "", ErrSessionNotFound
+	}
+	rec.session.LastSeen = now
+	rec.expires = now.Add(r.expire)
+	return rec.user, nil
+}
+
+func (r *records) List(ctx context.Context, id string) ([]Session, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	var sessions []Session
+	for _, rec := range r.sessions {
+		if rec.user == id && now.Before(rec.expires) {
+			sessions = append(sessions, rec.session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+func (r *records) Owner(ctx context.Context, sessionID string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rec, ok := r.sessions[sessionID]
+	if !ok || !time.Now().Before(rec.expires) {
+		return "", ErrSessionNotFound
+	}
+	return rec.user, nil
+}
+
+func (r *records) Remove(ctx context.Context, id, sessionID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if rec, ok := r.sessions[sessionID]; ok && rec.user == id {
+		delete(r.sessions, sessionID)
+	}
+	return nil
+}
+
+func (r *records) RemoveUser(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for sessionID, rec := range r.sessions {
+		if rec.user == id {
+			delete(r.sessions, sessionID)
+		}
+	}
+	return nil
+}
+
+// prune removes sessions expired at now, mutex must be held.
+func (r *records) prune(now time.Time) {
+	for id, rec := range r.sessions {
+		if !now.Before(rec.expires) {
+			delete(r.sessions, id)
+		}
+	}
+}
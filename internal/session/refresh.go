@@ -0,0 +1,157 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Errors of refresh tokens, returned to the client as ErrAuth.
+var (
+	ErrRefreshInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshReused  = errors.New("refresh token is reused, session is revoked")
+)
+
+// RefreshGrace is the time a rotated refresh token is still accepted, so that concurrent refreshes with the same
+// token, like ones of two tabs of a browser, are not taken for reuse. Token stolen and used within it is not detected.
+const RefreshGrace = 10 * time.Second
+
+// RefreshTokens keeps refresh tokens issued on login. Each login starts a family of tokens identified by id of
+// the session of the login, and each refresh rotates the token: it is marked used and a new one of the family is
+// issued. Presenting a used token again after grace time means it was stolen, so the whole family is revoked.
+// Tokens are kept hashed like session ids.
+type RefreshTokens interface {
+	// Issue starts new family of user id for session of family and returns it's first token.
+	Issue(ctx context.Context, id, family string) (string, error)
+	// Rotate consumes token and returns it's user id and family with next token of the family.
+	// ErrRefreshReused is returned with the user and family for token used before grace time, and the family is
+	// revoked. Token used within grace time gets another next token.
+	Rotate(ctx context.Context, token string) (id, family, next string, err error)
+	// Revoke revokes family of token, nothing is done for unknown token.
+	Revoke(ctx context.Context, token string) error
+	// RevokeSession revokes family of session of user id, nothing is done for unknown family or one of other user.
+	RevokeSession(ctx context.Context, id, family string) error
+	// RevokeUser revokes every family of user id.
+	RevokeUser(ctx context.Context, id string) error
+}
+
+// memoryRefreshTokens is RefreshTokens of a single server, tokens are lost on restart.
+type memoryRefreshTokens struct {
+	mutex    sync.Mutex
+	expire   time.Duration
+	grace    time.Duration
+	tokens   map[string]*refreshToken  // by hash of the token
+	families map[string]*refreshFamily // by family id
+}
+
+type refreshToken struct {
+	family  string
+	expires time.Time
+	used    time.Time // zero until the token is rotated
+}
+
+// refreshFamily is the chain of refresh tokens of one login.
+type refreshFamily struct {
+	user   string
+	tokens []string // hashes of tokens of the family
+}
+
+// NewMemoryRefreshTokens create RefreshTokens in memory, tokens expire after expire and used ones are accepted again
+// within grace, see RefreshGrace.
+func NewMemoryRefreshTokens(expire, grace time.Duration) RefreshTokens {
+	return &memoryRefreshTokens{
+		expire:   expire,
+		grace:    grace,
+		tokens:   make(map[string]*refreshToken),
+		families: make(map[string]*refreshFamily),
+	}
+}
+
+func (r *memoryRefreshTokens) Issue(ctx context.Context, id, family string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.prune(time.Now())
+	r.families[family] = &refreshFamily{user: id}
+	return r.add(family)
+}
+
+// add issues new token of family, mutex must be held.
+func (r *memoryRefreshTokens) add(family string) (string, error) {
+	token, err := NewID()
+	if err != nil {
+		return "", err
+	}
+	hash := HashID(token)
+	r.tokens[hash] = &refreshToken{family: family, expires: time.Now().Add(r.expire)}
+	f := r.families[family]
+	f.tokens = append(f.tokens, hash)
+	return token, nil
+}
+
+func (r *memoryRefreshTokens) Rotate(ctx context.Context, token string) (string, string, string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	t, ok := r.tokens[HashID(token)]
+	if !ok || now.After(t.expires) {
+		return "", "", "", ErrRefreshInvalid
+	}
+	user := r.families[t.family].user
+	if !t.used.IsZero() && now.Sub(t.used) > r.grace {
+		r.revokeFamily(t.family)
+		return user, t.family, "", ErrRefreshReused
+	}
+	if t.used.IsZero() {
+		t.used = now
+	}
+	next, err := r.add(t.family)
+	return user, t.family, next, err
+}
+
+func (r *memoryRefreshTokens) Revoke(ctx context.Context, token string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if t, ok := r.tokens[HashID(token)]; ok {
+		r.revokeFamily(t.family)
+	}
+	return nil
+}
+
+func (r *memoryRefreshTokens) RevokeSession(ctx context.Context, id, family string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if f, ok := r.families[family]; ok && f.user == id {
+		r.revokeFamily(family)
+	}
+	return nil
+}
+
+func (r *memoryRefreshTokens) RevokeUser(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for family, f := range r.families {
+		if f.user == id {
+			r.revokeFamily(family)
+		}
+	}
+	return nil
+}
+
+// revokeFamily removes family and it's tokens, mutex must be held.
+func (r *memoryRefreshTokens) revokeFamily(family string) {
+	for _, hash := range r.families[family].tokens {
+		delete(r.tokens, hash)
+	}
+	delete(r.families, family)
+}
+
+// prune removes families whose tokens are all expired, mutex must be held.
+func (r *memoryRefreshTokens) prune(now time.Time) {
+	for family, f := range r.families {
+		last := r.tokens[f.tokens[len(f.tokens)-1]]
+		if now.After(last.expires) {
+			r.revokeFamily(family)
+		}
+	}
+}
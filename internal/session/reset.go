@@ -0,0 +1,102 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrResetTokenInvalid is returned for password reset token which is unknown, used or expired.
+var ErrResetTokenInvalid = errors.New("reset token is invalid or expired")
+
+// ResetTokens keeps one-time password reset tokens until they are used or expire. Tokens are kept hashed, so that
+// they can't be taken from the store.
+type ResetTokens interface {
+	// Issue creates new token of user id, returned with it's expiration time.
+	Issue(ctx context.Context, id string) (string, time.Time, error)
+	// Use consumes token and returns it's user id, ErrResetTokenInvalid if it is unknown, used or expired.
+	Use(ctx context.Context, token string) (string, error)
+	// RevokeUser revokes every token of user id, so that links sent before the password changed don't work.
+	RevokeUser(ctx context.Context, id string) error
+}
+
+// memoryResetTokens is ResetTokens of a single server, tokens are lost on restart.
+type memoryResetTokens struct {
+	mutex  sync.Mutex
+	expire time.Duration
+	tokens map[string]resetToken      // by hash of the token
+	users  map[string]map[string]bool // hashes of tokens of each user
+}
+
+type resetToken struct {
+	id      string // user to reset password of
+	expires time.Time
+}
+
+// NewMemoryResetTokens create ResetTokens in memory, tokens expire after expire.
+func NewMemoryResetTokens(expire time.Duration) ResetTokens {
+	return &memoryResetTokens{
+		expire: expire,
+		tokens: make(map[string]resetToken),
+		users:  make(map[string]map[string]bool),
+	}
+}
+
+func (r *memoryResetTokens) Issue(ctx context.Context, id string) (string, time.Time, error) {
+	token, err := NewID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now()
+	expires := now.Add(r.expire)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// remove expired tokens so they don't pile up
+	for hash, t := range r.tokens {
+		if now.After(t.expires) {
+			r.remove(hash, t.id)
+		}
+	}
+	hash := HashID(token)
+	r.tokens[hash] = resetToken{id: id, expires: expires}
+	if r.users[id] == nil {
+		r.users[id] = make(map[string]bool)
+	}
+	r.users[id][hash] = true
+	return token, expires, nil
+}
+
+func (r *memoryResetTokens) Use(ctx context.Context, token string) (string, error) {
+	hash := HashID(token)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t, ok := r.tokens[hash]
+	if !ok {
+		return "", ErrResetTokenInvalid
+	}
+	r.remove(hash, t.id)
+	if !time.Now().Before(t.expires) {
+		return "", ErrResetTokenInvalid
+	}
+	return t.id, nil
+}
+
+func (r *memoryResetTokens) RevokeUser(ctx context.Context, id string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for hash := range r.users[id] {
+		delete(r.tokens, hash)
+	}
+	delete(r.users, id)
+	return nil
+}
+
+// remove removes token of hash of user id, mutex must be held.
+func (r *memoryResetTokens) remove(hash, id string) {
+	delete(r.tokens, hash)
+	delete(r.users[id], hash)
+	if len(r.users[id]) == 0 {
+		delete(r.users, id)
+	}
+}
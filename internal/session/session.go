@@ -0,0 +1,168 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
+)
+
+// Manager issues access tokens of users and authenticates them. Tokens are stateless JWT of jwt.TokenIssuer,
+// see JWTManager, or opaque ids of sessions kept by the server like MemoryStore, which are revoked instantly
+// and expire when not used for a while.
+type Manager interface {
+	// Issue starts new session of user id on device, and returns access token of it and id of the session.
+	Issue(ctx context.Context, id string, device Device) (token, sessionID string, err error)
+	// Authenticate returns id of the owner of token and id of it's session, or error if token is invalid, expired
+	// or revoked.
+	Authenticate(ctx context.Context, token string) (id, sessionID string, err error)
+	// Revoke revokes session of token. Expired or unknown token is ignored.
+	Revoke(ctx context.Context, token string) error
+	// RevokeUser revokes every session of user id started until now, logging the user out of all devices.
+	RevokeUser(ctx context.Context, id string) error
+	// List returns sessions of user id, most recently seen first.
+	List(ctx context.Context, id string) ([]Session, error)
+	// RevokeSession revokes session of sessionID of user id. Unknown session, or one of other user, is ignored.
+	RevokeSession(ctx context.Context, id, sessionID string) error
+}
+
+// Renewer is implemented by Manager whose access tokens are short-lived and renewed by refresh tokens,
+// like JWTManager.
+type Renewer interface {
+	// Renew issues new access token of session of sessionID of user id.
+	Renew(ctx context.Context, id, sessionID string) (string, error)
+}
+
+// Device is the device a session is logged in from, as reported by web tier.
+type Device struct {
+	UserAgent string
+	RemoteIP  string
+}
+
+// Session is a login of a user.
+type Session struct {
+	ID string // identifies the session to list and revoke it, it is not usable as access token
+	Device
+	Created  time.Time
+	LastSeen time.Time // last time the session was authenticated
+}
+
+// ErrSessionNotFound is returned by Authenticate of session stores for session unknown, expired or revoked.
+var ErrSessionNotFound = errors.New("session not found")
+
+// NewID creates random session id given to the user as access token.
+func NewID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashID returns hash of session id, under which session stores keep it so that leaked store doesn't leak
+// usable tokens.
+func HashID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// JWTManager is Manager of stateless JWT issued by TokenIssuer. Tokens carry id of their session and are revoked
+// through revocation list of the issuer. Sessions are recorded in Records only to be listed, tokens are
+// authenticated without them.
+type JWTManager struct {
+	issuer  *jwt.TokenIssuer
+	records Records
+}
+
+// NewJWTManager create Manager of tokens of issuer recording sessions in records, which should forget sessions not
+// seen for lifetime of refresh tokens renewing them. Sessions listed are the ones started on this server since it
+// started if records are in memory, see NewMemoryRecords.
+func NewJWTManager(issuer *jwt.TokenIssuer, records Records) *JWTManager {
+	return &JWTManager{issuer: issuer, records: records}
+}
+
+var _ Manager = (*JWTManager)(nil)
+var _ Renewer = (*JWTManager)(nil)
+
+func (m *JWTManager) Issue(ctx context.Context, id string, device Device) (string, string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	sessionID := hex.EncodeToString(b)
+	token := m.issuer.GenerateSessionToken(id, sessionID)
+	if token == "" {
+		return "", "", errors.New("cannot generate token")
+	}
+	if err := m.records.Add(ctx, id, sessionID, device); err != nil {
+		return "", "", err
+	}
+	return token, sessionID, nil
+}
+
+func (m *JWTManager) Authenticate(ctx context.Context, token string) (string, string, error) {
+	c, err := m.issuer.ParseToken(token)
+	if err != nil {
+		return "", "", err
+	}
+	if c.SessionID != "" {
+		// last seen time is best effort, token is valid without it's record
+		m.records.Touch(ctx, c.SessionID)
+	}
+	return c.Subject, c.SessionID, nil
+}
+
+func (m *JWTManager) Renew(ctx context.Context, id, sessionID string) (string, error) {
+	token := m.issuer.GenerateSessionToken(id, sessionID)
+	if token == "" {
+		return "", errors.New("cannot generate token")
+	}
+	m.records.Touch(ctx, sessionID)
+	return token, nil
+}
+
+func (m *JWTManager) Revoke(ctx context.Context, token string) error {
+	c, err := m.issuer.ParseToken(token)
+	if errors.Is(err, jwt.TokenExpiredError{}) || errors.Is(err, jwt.ErrTokenRevoked) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if c.SessionID == "" {
+		// token issued before sessions
+		return m.issuer.RevokeToken(token)
+	}
+	return m.RevokeSession(ctx, c.Subject, c.SessionID)
+}
+
+func (m *JWTManager) RevokeUser(ctx context.Context, id string) error {
+	if err := m.records.RemoveUser(ctx, id); err != nil {
+		return err
+	}
+	return m.issuer.RevokeUser(id)
+}
+
+func (m *JWTManager) List(ctx context.Context, id string) ([]Session, error) {
+	return m.records.List(ctx, id)
+}
+
+// RevokeSession revokes session of user id, nothing is done for session not recorded or of other user, since the
+// owner of it can't be told.
+func (m *JWTManager) RevokeSession(ctx context.Context, id, sessionID string) error {
+	owner, err := m.records.Owner(ctx, sessionID)
+	if err == ErrSessionNotFound || err == nil && owner != id {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = m.records.Remove(ctx, id, sessionID); err != nil {
+		return err
+	}
+	return m.issuer.RevokeSession(sessionID)
+}
@@ -0,0 +1,71 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
+)
+
+func TestJWTManagerSessions(t *testing.T) {
+	m := NewJWTManager(jwt.NewTokenIssuer("secret", time.Minute), NewMemoryRecords(time.Hour))
+	ctx := context.Background()
+	token, sessionID, err := m.Issue(ctx, "song", Device{UserAgent: "phone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, sid, err := m.Authenticate(ctx, token); id != "song" || sid != sessionID || err != nil {
+		t.Errorf("got %q, %q, %v", id, sid, err)
+	}
+	// renewed token belongs to the same session, and is revoked with it
+	renewed, err := m.Renew(ctx, "song", sessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, sid, _ := m.Authenticate(ctx, renewed); sid != sessionID {
+		t.Errorf("renewed token: got session %q", sid)
+	}
+	other, otherID, _ := m.Issue(ctx, "song", Device{UserAgent: "laptop"})
+	if sessions, _ := m.List(ctx, "song"); len(sessions) != 2 {
+		t.Fatalf("sessions: got %+v", sessions)
+	}
+
+	m.RevokeSession(ctx, "other", sessionID)
+	if _, _, err := m.Authenticate(ctx, token); err != nil {
+		t.Errorf("session revoked by other user: %v", err)
+	}
+	m.RevokeSession(ctx, "song", sessionID)
+	for _, tk := range []string{token, renewed} {
+		if _, _, err := m.Authenticate(ctx, tk); err != jwt.ErrTokenRevoked {
+			t.Errorf("token of revoked session: got %v", err)
+		}
+	}
+	if sessions, _ := m.List(ctx, "song"); len(sessions) != 1 || sessions[0].ID != otherID {
+		t.Errorf("sessions after revoke: got %+v", sessions)
+	}
+	// logging out revokes the whole session
+	if err := m.Revoke(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ := m.List(ctx, "song"); len(sessions) != 0 {
+		t.Errorf("sessions after logout: got %+v", sessions)
+	}
+}
+
+func TestJWTManagerRevokeUnrecordedSession(t *testing.T) {
+	issuer := jwt.NewTokenIssuer("secret", time.Minute)
+	m := NewJWTManager(issuer, NewMemoryRecords(time.Hour))
+	ctx := context.Background()
+	// session recorded elsewhere, it's owner can't be told
+	token, sessionID, err := NewJWTManager(issuer, NewMemoryRecords(time.Hour)).Issue(ctx, "song", Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.RevokeSession(ctx, "other", sessionID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = m.Authenticate(ctx, token); err != nil {
+		t.Errorf("unrecorded session revoked: %v", err)
+	}
+}
@@ -1,166 +1,198 @@
 package message
 
 import (
-	"fmt"
+	"context"
+
+	"google.golang.org/protobuf/proto"
 )
 
+// Invoker sends a request message and returns it's response message.
+// Generated service clients send their requests through Invoker.
+type Invoker interface {
+	Invoke(ctx context.Context, req proto.Message) (proto.Message, error)
+}
+
 // Client to request and get response from backend TCP server
 // Client use tcp connection pool(MsgStreamPool) whenever there is request.
 type Client struct {
 	pool *MsgStreamPool
+	user UserServiceClient
 }
 
-// Create New Client to connect server.
-func NewClient(host, port string, maxConn int) *Client {
-	return &Client{
-		pool: NewMsgStreamPool("tcp", host, port, int32(maxConn)),
+// Create New Client to connect server. Client opens at most config.MaxConn connections
+// and pipelines up to config.MaxCalls concurrent requests over each connection.
+func NewClient(host, port string, config PoolConfig) *Client {
+	c := &Client{
+		pool: NewMsgStreamPool("tcp", host, port, config),
 	}
+	c.user = NewUserServiceClient(c)
+	return c
 }
 
-type AuthError struct{}
-
-func (e AuthError) Error() string {
-	return fmt.Sprintf("Authentication fail")
-}
-
-type DBError struct{}
-
-func (e DBError) Error() string {
-	return "DB error"
+// responder is implemented by response messages which carry Response.
+type responder interface {
+	GetResponse() *Response
 }
 
-type InputError struct{}
-
-func (e InputError) Error() string {
-	return "Wrong input"
+// GetResponse returns the response itself, so that Response can be checked same as messages embedding it.
+func (x *Response) GetResponse() *Response {
+	return x
 }
 
-type UnknownError struct{}
-
-func (e UnknownError) Error() string {
-	return "Unknown Error"
-}
+// Invoke sends request through a stream from the pool and wait for it's response.
+// If response carries error code, *Error with the code and message from the server is returned.
+// Deadline of ctx limits the whole call, TimeoutError is returned if it passes.
+// return error on network or backend server failure
+func (c *Client) Invoke(ctx context.Context, req proto.Message) (proto.Message, error) {
+	stream, err := c.pool.GetMsgStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := stream.Call(ctx, req)
+	if err != nil {
+		// timed out call leaves the stream usable unless it was interrupted while writing
+		if stream.Err() != nil {
+			c.pool.destroyMsgStream(stream)
+		} else {
+			c.pool.closeMsgStream(stream)
+		}
+		return nil, err
+	}
+	c.pool.closeMsgStream(stream)
 
-// function to create error from TCP message error code
-func getErrorFromCode(code uint32) error {
-	switch code {
-	case 1:
-		return AuthError{}
-	case 2:
-		return DBError{}
-	case 3:
-		return InputError{}
-	default:
-		return UnknownError{}
+	if r, ok := res.(responder); ok {
+		if err = errorFromResponse(r.GetResponse()); err != nil {
+			return nil, err
+		}
 	}
+	return res, nil
 }
 
-// try login in backend server, If success, token is returned.
-// return error on network or backend server failure, in this case token is empty string
-func (c *Client) Login(id, password string) (string, error) {
-	stream, err := c.pool.GetMsgStream()
-	if err != nil {
-		return "", err
-	}
-	err = stream.WriteMsg(&LoginRequest{
+// try login in backend server, If success, access token and refresh token are returned.
+// return error on network or backend server failure, in this case tokens are empty string
+func (c *Client) Login(ctx context.Context, id, password string, device *Device) (string, string, error) {
+	res, err := c.user.Login(ctx, &LoginRequest{
 		Id:       id,
 		Password: password,
+		Device:   device,
 	})
 	if err != nil {
-		c.pool.destroyMsgStream(stream)
-		return "", err
-	}
-	resMsg, err := stream.ReadMsg()
-	if err != nil {
-		c.pool.destroyMsgStream(stream)
-		return "", err
-	}
-	c.pool.closeMsgStream(stream)
-
-	logRes := resMsg.(*LoginResponse)
-	if logRes.Response.Code > uint32(0) {
-		return "", getErrorFromCode(logRes.Response.Code)
+		return "", "", err
 	}
-	return logRes.Token, nil
+	return res.Token, res.RefreshToken, nil
 }
 
 // Get user information from backend TCP server.
 // return error on network or backend server failure
-func (c *Client) GetUserInfo(token string) (*User, error) {
-	stream, err := c.pool.GetMsgStream()
+func (c *Client) GetUserInfo(ctx context.Context, token string) (*User, error) {
+	res, err := c.user.GetUserInfo(ctx, &GetUserInfoRequest{Token: token})
 	if err != nil {
 		return nil, err
 	}
-	err = stream.WriteMsg(&GetUserInfoRequest{Token: token})
-	if err != nil {
-		c.pool.destroyMsgStream(stream)
-		return nil, err
-	}
-	resMsg, err := stream.ReadMsg()
-	if err != nil {
-		c.pool.destroyMsgStream(stream)
-		return nil, err
-	}
-	c.pool.closeMsgStream(stream)
-
-	getRes := resMsg.(*GetUserInfoResponse)
-	if getRes.Response.Code > uint32(0) {
-		return nil, getErrorFromCode(getRes.Response.Code)
-	}
-	return getRes.User, nil
+	return res.User, nil
 }
 
 // Authenticate JWT access token
 // return error on network or backend server failure
-func (c *Client) Authenticate(token string) error {
-	stream, err := c.pool.GetMsgStream()
-	if err != nil {
-		return err
-	}
-	err = stream.WriteMsg(&AuthRequest{Token: token})
-	if err != nil {
-		c.pool.destroyMsgStream(stream)
-		return err
-	}
-	resMsg, err := stream.ReadMsg()
-	if err != nil {
-		c.pool.destroyMsgStream(stream)
-		return err
-	}
-	c.pool.closeMsgStream(stream)
-
-	res := resMsg.(*Response)
-	if res.Code > uint32(0) {
-		return getErrorFromCode(res.Code)
-	}
-	return nil
+func (c *Client) Authenticate(ctx context.Context, token string) error {
+	_, err := c.user.Authenticate(ctx, &AuthRequest{Token: token})
+	return err
 }
 
 // Edit User information from backend TCP server
 // return error on network or backend server failure
-func (c *Client) EditUserInfo(token string, user *User) error {
-	stream, err := c.pool.GetMsgStream()
-	if err != nil {
-		return err
-	}
-	err = stream.WriteMsg(&EditUserInfoRequest{
+func (c *Client) EditUserInfo(ctx context.Context, token string, user *User) error {
+	_, err := c.user.EditUserInfo(ctx, &EditUserInfoRequest{
 		Token: token,
 		User:  user,
 	})
+	return err
+}
+
+// Create new user in backend TCP server, the user can log in right after.
+// return error on network or backend server failure, or if the user is invalid or exists already
+func (c *Client) CreateUser(ctx context.Context, id, password, nickname string) error {
+	_, err := c.user.CreateUser(ctx, &CreateUserRequest{
+		Id:       id,
+		Password: password,
+		Nickname: nickname,
+	})
+	return err
+}
+
+// Change password of the owner of token, the token is revoked on success.
+// return error on network or backend server failure, or if current password is wrong or new one is invalid
+func (c *Client) ChangePassword(ctx context.Context, token, currentPassword, newPassword string) error {
+	_, err := c.user.ChangePassword(ctx, &ChangePasswordRequest{
+		Token:           token,
+		CurrentPassword: currentPassword,
+		NewPassword:     newPassword,
+	})
+	return err
+}
+
+// Request password reset token of user id to be sent to the user.
+// return error on network or backend server failure
+func (c *Client) RequestPasswordReset(ctx context.Context, id string) error {
+	_, err := c.user.RequestPasswordReset(ctx, &PasswordResetRequest{Id: id})
+	return err
+}
+
+// Reset password with one-time reset token sent to the user.
+// return error on network or backend server failure, or if the reset token or new password is invalid
+func (c *Client) ResetPassword(ctx context.Context, resetToken, newPassword string) error {
+	_, err := c.user.ResetPassword(ctx, &ResetPasswordRequest{
+		ResetToken:  resetToken,
+		NewPassword: newPassword,
+	})
+	return err
+}
+
+// Logout revokes token and refreshToken, or every token of it's owner if allDevices is true.
+// return error on network or backend server failure, or if token is already invalid
+func (c *Client) Logout(ctx context.Context, token, refreshToken string, allDevices bool) error {
+	_, err := c.user.Logout(ctx, &LogoutRequest{
+		Token:        token,
+		AllDevices:   allDevices,
+		RefreshToken: refreshToken,
+	})
+	return err
+}
+
+// Refresh access token with refresh token, new access token and refresh token are returned.
+// The refresh token can't be used again.
+// return error on network or backend server failure, or if refresh token is invalid
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (string, string, error) {
+	res, err := c.user.RefreshToken(ctx, &RefreshTokenRequest{RefreshToken: refreshToken})
 	if err != nil {
-		c.pool.destroyMsgStream(stream)
-		return err
+		return "", "", err
 	}
-	resMsg, err := stream.ReadMsg()
+	return res.Token, res.RefreshToken, nil
+}
+
+// Get public keys verifying access tokens as JSON Web Key Set, see jwt.RemoteKeySet.
+// return error on network or backend server failure
+func (c *Client) PublicKeys(ctx context.Context) ([]byte, error) {
+	res, err := c.user.PublicKeys(ctx, &PublicKeysRequest{})
 	if err != nil {
-		c.pool.destroyMsgStream(stream)
-		return err
+		return nil, err
 	}
-	c.pool.closeMsgStream(stream)
+	return []byte(res.Jwks), nil
+}
 
-	res := resMsg.(*Response)
-	if res.Code > uint32(0) {
-		return getErrorFromCode(res.Code)
+// List sessions of the owner of access token, most recently seen first.
+// return error on network or backend server failure
+func (c *Client) ListSessions(ctx context.Context, token string) ([]*Session, error) {
+	res, err := c.user.ListSessions(ctx, &ListSessionsRequest{Token: token})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return res.Sessions, nil
+}
+
+// Log the owner of access token out of session of sessionID, listed by ListSessions.
+// return error on network or backend server failure
+func (c *Client) RevokeSession(ctx context.Context, token, sessionID string) error {
+	_, err := c.user.RevokeSession(ctx, &RevokeSessionRequest{Token: token, SessionId: sessionID})
+	return err
 }
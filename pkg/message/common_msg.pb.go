@@ -0,0 +1,67 @@
+// Code generated by protoc-gen-gomsg. DO NOT EDIT.
+// source: common.proto
+
+package message
+
+import (
+	context "context"
+	proto "google.golang.org/protobuf/proto"
+)
+
+func init() {
+	RegisterFile(File_common_proto)
+}
+
+// HealthClient is the client API for Health service.
+type HealthClient interface {
+	Check(ctx context.Context, req *HealthcheckMessage) (*HealthcheckMessage, error)
+}
+
+type healthClient struct {
+	cc Invoker
+}
+
+// NewHealthClient create new HealthClient sending requests through cc.
+func NewHealthClient(cc Invoker) HealthClient {
+	return &healthClient{cc}
+}
+
+func (c *healthClient) Check(ctx context.Context, req *HealthcheckMessage) (*HealthcheckMessage, error) {
+	res, err := c.cc.Invoke(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out, ok := res.(*HealthcheckMessage)
+	if !ok {
+		return nil, UnexpectedResponseError{Response: res}
+	}
+	return out, nil
+}
+
+// HealthServer is the server API for Health service.
+// Error returned by a method is sent to the client as error code of the response.
+type HealthServer interface {
+	Check(ctx context.Context, req *HealthcheckMessage) (*HealthcheckMessage, error)
+}
+
+// RegisterHealthServer registers every method of srv to server s.
+func RegisterHealthServer(s *Server, srv HealthServer) {
+	s.RegisterService(&_Health_serviceDesc, srv)
+}
+
+func _Health_Check_Handler(srv interface{}, ctx context.Context, req proto.Message) (proto.Message, error) {
+	return srv.(HealthServer).Check(ctx, req.(*HealthcheckMessage))
+}
+
+var _Health_serviceDesc = ServiceDesc{
+	ServiceName: "message.Health",
+	HandlerType: (*HealthServer)(nil),
+	Methods: []MethodDesc{
+		{
+			MethodName: "Check",
+			Request:    &HealthcheckMessage{},
+			Response:   &HealthcheckMessage{},
+			Handler:    _Health_Check_Handler,
+		},
+	},
+}
@@ -0,0 +1,159 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Error is an error returned by handler and sent to the client in Response.
+// Errors of same code match each other with errors.Is, so errors.Is(err, ErrAuth) checks the code
+// whatever the message is, and errors.As(err, &e) gives the message and details sent by the server.
+type Error struct {
+	Code    ErrorCode
+	Message string            // human readable description, shown to the user
+	Details map[string]string // optional details
+}
+
+// Errors of each code with default message.
+var (
+	ErrAuth     = &Error{Code: ErrorCode_AUTH_FAILED, Message: "Authentication fail"}
+	ErrDB       = &Error{Code: ErrorCode_DB_ERROR, Message: "DB error"}
+	ErrInput    = &Error{Code: ErrorCode_INVALID_INPUT, Message: "Wrong input"}
+	ErrUnknown  = &Error{Code: ErrorCode_UNKNOWN, Message: "Unknown Error"}
+	ErrNotFound = &Error{Code: ErrorCode_NOT_FOUND, Message: "Not found"}
+	ErrInternal = &Error{Code: ErrorCode_INTERNAL, Message: "Internal server error"}
+	ErrExists   = &Error{Code: ErrorCode_ALREADY_EXISTS, Message: "Already exists"}
+	ErrDenied   = &Error{Code: ErrorCode_PERMISSION_DENIED, Message: "Permission denied"}
+)
+
+// NewError create error of code with formatted message.
+func NewError(code ErrorCode, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Code.String()
+	}
+	return e.Message
+}
+
+// Is reports whether target is an Error of same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithDetail returns copy of the error with detail added.
+func (e *Error) WithDetail(key, value string) *Error {
+	details := make(map[string]string, len(e.Details)+1)
+	for k, v := range e.Details {
+		details[k] = v
+	}
+	details[key] = value
+	return &Error{Code: e.Code, Message: e.Message, Details: details}
+}
+
+// TimeoutError occurs when deadline of a request passed before getting it's response.
+type TimeoutError struct{}
+
+func (e TimeoutError) Error() string {
+	return "request timeout"
+}
+
+// Timeout reports the error is a timeout, same as net.Error.
+func (e TimeoutError) Timeout() bool {
+	return true
+}
+
+// Is makes errors.Is(err, context.DeadlineExceeded) true for TimeoutError.
+func (e TimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// contextError converts error of done ctx to the error returned to caller.
+// Deadline exceeded becomes TimeoutError, cancellation is returned as is.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return TimeoutError{}
+	}
+	return ctx.Err()
+}
+
+// UnexpectedResponseError occurs when server answers a request with message of unexpected type.
+type UnexpectedResponseError struct {
+	Response proto.Message
+}
+
+func (e UnexpectedResponseError) Error() string {
+	return fmt.Sprintf("unexpected response message %T", e.Response)
+}
+
+// protocolErrorCodes maps protocol errors of reading frames and handshake to code sent in ProtocolError message.
+var protocolErrorCodes = []struct {
+	err  error
+	code ProtocolError_Code
+}{
+	{ErrMalformedMessage, ProtocolError_MALFORMED},
+	{ErrFrameTooLarge, ProtocolError_FRAME_TOO_LARGE},
+	{ErrUnknownMessageType, ProtocolError_UNKNOWN_MESSAGE_TYPE},
+	{ErrTruncated, ProtocolError_TRUNCATED},
+	{ErrIncompatibleVersion, ProtocolError_INCOMPATIBLE_VERSION},
+	{ErrHandshakeRequired, ProtocolError_HANDSHAKE_REQUIRED},
+}
+
+// newProtocolErrorMsg create ProtocolError message telling the peer why it's frame was rejected.
+// ok is false if err is not a protocol error, e.g. network error.
+func newProtocolErrorMsg(err error) (msg *ProtocolError, ok bool) {
+	for _, pe := range protocolErrorCodes {
+		if errors.Is(err, pe.err) {
+			return &ProtocolError{Code: pe.code, Reason: err.Error()}, true
+		}
+	}
+	return nil, false
+}
+
+// RemoteProtocolError occurs when the peer rejected a frame sent to it with ProtocolError message.
+// It wraps the protocol error of it's code, so errors.Is(err, ErrFrameTooLarge) works on it.
+type RemoteProtocolError struct {
+	Code   ProtocolError_Code
+	Reason string
+}
+
+func (e RemoteProtocolError) Error() string {
+	return "rejected by peer: " + e.Reason
+}
+
+// Unwrap returns protocol error of the code.
+func (e RemoteProtocolError) Unwrap() error {
+	for _, pe := range protocolErrorCodes {
+		if pe.code == e.Code {
+			return pe.err
+		}
+	}
+	return nil
+}
+
+// errorFromResponse converts failed Response to error, nil if the response is successful.
+func errorFromResponse(res *Response) error {
+	if res.GetCode() == ErrorCode_OK {
+		return nil
+	}
+	return &Error{Code: res.Code, Message: res.Message, Details: res.Details}
+}
+
+// responseFromError converts error returned by handler to Response sent to the client.
+// Errors other than Error are reported as ErrUnknown, so that internal errors are not exposed to the client.
+func responseFromError(err error) *Response {
+	if err == nil {
+		return &Response{Code: ErrorCode_OK}
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		e = ErrUnknown
+	}
+	return &Response{Code: e.Code, Message: e.Message, Details: e.Details}
+}
@@ -0,0 +1,141 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtocolVersion is the version of the protocol spoken by this package. It must be increased
+// whenever message numbers or message meanings change in a way older peers can't understand.
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest protocol version this package still speaks.
+const MinProtocolVersion = 1
+
+// FeatureMultiplexing means many requests are in flight on a connection at the same time and
+// their responses may come in any order. Without it, requests are handled one by one.
+const FeatureMultiplexing = "multiplexing"
+
+// supportedFeatures are optional features offered to the peer during handshake.
+var supportedFeatures = []string{FeatureMultiplexing}
+
+// Build identifies the binary in handshake, so peers can log who they are talking to.
+// It is set at link time with -ldflags "-X git.garena.com/youngiek.song/entry_task/pkg/message.Build=...".
+var Build = "unknown"
+
+var (
+	// ErrIncompatibleVersion occurs when peers have no protocol version in common.
+	ErrIncompatibleVersion = errors.New("incompatible protocol version")
+	// ErrHandshakeRequired occurs when the first message of a connection is not Hello.
+	ErrHandshakeRequired = errors.New("handshake required")
+)
+
+// Handshake is the result of hello exchange on a connection.
+type Handshake struct {
+	Version   uint32   // negotiated protocol version
+	Features  []string // optional features both peers support
+	PeerBuild string   // build info of the peer
+}
+
+// HasFeature reports whether feature has been negotiated.
+func (h Handshake) HasFeature(feature string) bool {
+	return hasFeature(h.Features, feature)
+}
+
+func hasFeature(features []string, feature string) bool {
+	for _, f := range features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// newHello create Hello message describing this peer.
+func newHello() *Hello {
+	return &Hello{Version: ProtocolVersion, Features: supportedFeatures, Build: Build}
+}
+
+// negotiate returns hello answering peer's hello, with highest version and features both peers support.
+func negotiate(peer *Hello) (*Hello, error) {
+	version := peer.Version
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+	if version < MinProtocolVersion {
+		return nil, fmt.Errorf("%w: peer speaks version %d (build %s), supported versions are %d to %d",
+			ErrIncompatibleVersion, peer.Version, peer.Build, MinProtocolVersion, ProtocolVersion)
+	}
+	var features []string
+	for _, f := range peer.Features {
+		if hasFeature(supportedFeatures, f) {
+			features = append(features, f)
+		}
+	}
+	return &Hello{Version: version, Features: features, Build: Build}, nil
+}
+
+// clientHandshake sends Hello on a new connection and waits for server's answer.
+// The stream is closed if ctx is done before the handshake is finished.
+func clientHandshake(ctx context.Context, stream *MsgStream) (Handshake, error) {
+	err := stream.WriteMsgContext(ctx, 0, newHello())
+	if err != nil {
+		return Handshake{}, err
+	}
+	type result struct {
+		reqID uint64
+		msg   proto.Message
+		err   error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		reqID, msg, err := stream.ReadMsg()
+		resCh <- result{reqID, msg, err}
+	}()
+	var res result
+	select {
+	case res = <-resCh:
+	case <-ctx.Done():
+		stream.Close()
+		return Handshake{}, contextError(ctx)
+	}
+	if res.err != nil {
+		return Handshake{}, res.err
+	}
+	switch msg := res.msg.(type) {
+	case *Hello:
+		if res.reqID != 0 || msg.Version < MinProtocolVersion || msg.Version > ProtocolVersion {
+			return Handshake{}, fmt.Errorf("%w: server answered version %d (build %s)", ErrIncompatibleVersion, msg.Version, msg.Build)
+		}
+		return Handshake{Version: msg.Version, Features: msg.Features, PeerBuild: msg.Build}, nil
+	case *ProtocolError:
+		return Handshake{}, RemoteProtocolError{Code: msg.Code, Reason: msg.Reason}
+	default:
+		return Handshake{}, fmt.Errorf("%w: server answered %T", ErrHandshakeRequired, msg)
+	}
+}
+
+// serverHandshake reads client's Hello and answers it. If the client is incompatible,
+// ErrIncompatibleVersion or ErrHandshakeRequired is returned and the connection should be rejected.
+func serverHandshake(stream *MsgStream) (Handshake, error) {
+	reqID, msg, err := stream.ReadMsg()
+	if err != nil {
+		return Handshake{}, err
+	}
+	hello, ok := msg.(*Hello)
+	if !ok || reqID != 0 {
+		return Handshake{}, fmt.Errorf("%w: first message is %T", ErrHandshakeRequired, msg)
+	}
+	res, err := negotiate(hello)
+	if err != nil {
+		return Handshake{}, err
+	}
+	err = stream.WriteMsg(0, res)
+	if err != nil {
+		return Handshake{}, err
+	}
+	return Handshake{Version: res.Version, Features: res.Features, PeerBuild: hello.Build}, nil
+}
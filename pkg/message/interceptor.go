@@ -0,0 +1,238 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// UnaryServerInfo describes the method a request is being dispatched to.
+type UnaryServerInfo struct {
+	Server     interface{} // implementation of the service
+	FullMethod string      // full name of the method, like /message.UserService/Login
+}
+
+// UnaryHandler handles a request, it is the method implementation or the rest of interceptor chain.
+type UnaryHandler func(ctx context.Context, req proto.Message) (proto.Message, error)
+
+// UnaryServerInterceptor intercepts every request handled by Server. It may inspect or modify ctx and req,
+// call handler to continue, and inspect or replace the response and error. Returning without calling handler
+// rejects the request with the error.
+type UnaryServerInterceptor func(ctx context.Context, req proto.Message, info *UnaryServerInfo, handler UnaryHandler) (proto.Message, error)
+
+// ChainUnaryInterceptors creates one interceptor running interceptors in order, first one is the outermost.
+func ChainUnaryInterceptors(interceptors ...UnaryServerInterceptor) UnaryServerInterceptor {
+	return func(ctx context.Context, req proto.Message, info *UnaryServerInfo, handler UnaryHandler) (proto.Message, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req proto.Message) (proto.Message, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// LoggingInterceptor logs every request with it's method, result and time taken.
+func LoggingInterceptor(logger *zap.Logger) UnaryServerInterceptor {
+	return func(ctx context.Context, req proto.Message, info *UnaryServerInfo, handler UnaryHandler) (proto.Message, error) {
+		start := time.Now()
+		res, err := handler(ctx, req)
+		fields := []zap.Field{
+			zap.String("remote", remoteAddr(ctx)),
+			zap.String("method", info.FullMethod),
+			zap.Duration("elapsed", time.Since(start)),
+		}
+		if id, ok := UserIDFromContext(ctx); ok {
+			fields = append(fields, zap.String("id", id))
+		}
+		if err != nil {
+			logger.Warn("Request failed", append(fields, zap.String("error", err.Error()))...)
+		} else {
+			logger.Info("Handled request", fields...)
+		}
+		return res, err
+	}
+}
+
+// RecoveryInterceptor recovers panic of the handler and rejects the request with ErrInternal,
+// so that a bug in one handler neither kills the server nor breaks the connection of the request.
+// The panic is logged with stack trace, and onPanic is called with the method to count it unless it's nil.
+func RecoveryInterceptor(logger *zap.Logger, onPanic func(method string)) UnaryServerInterceptor {
+	return func(ctx context.Context, req proto.Message, info *UnaryServerInfo, handler UnaryHandler) (res proto.Message, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				if onPanic != nil {
+					onPanic(info.FullMethod)
+				}
+				logger.Error("Panic handling request", zap.String("remote", remoteAddr(ctx)), zap.String("method", info.FullMethod),
+					zap.Any("panic", r), zap.ByteString("stack", debug.Stack()))
+				res, err = nil, ErrInternal
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// tokenRequest is implemented by request messages carrying access token of the user.
+type tokenRequest interface {
+	GetToken() string
+}
+
+// userIDKey is context key of id of the user authenticated by AuthInterceptor.
+type userIDKey struct{}
+
+// UserIDFromContext returns id of the user whose token was authenticated by AuthInterceptor.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(userIDKey{}).(string)
+	return id, ok
+}
+
+// sessionIDKey is context key of id of the session authenticated by AuthInterceptor.
+type sessionIDKey struct{}
+
+// SessionIDFromContext returns id of the session whose token was authenticated by AuthInterceptor.
+// Empty id is returned for token without session, like JWT issued before sessions were recorded.
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(sessionIDKey{}).(string)
+	return id, ok
+}
+
+// tokenErrorReasons are reasons of token authentication failure, sent as reason detail of ErrAuth.
+var tokenErrorReasons = []struct {
+	err    error
+	reason string
+}{
+	{jwt.TokenExpiredError{}, "expired"},
+	{jwt.ErrTokenNotValidYet, "not_yet_valid"},
+	{jwt.ErrTokenRevoked, "revoked"},
+	{jwt.ErrInvalidSignature, "invalid_signature"},
+	{jwt.ErrKeyNotFound, "invalid_signature"},
+	{jwt.ErrInvalidIssuer, "invalid_issuer"},
+	{jwt.ErrInvalidAudience, "invalid_audience"},
+	{jwt.ErrMalformedToken, "malformed"},
+	{jwt.ErrTokenMissingClaim, "malformed"},
+	{session.ErrSessionNotFound, "invalid_session"},
+}
+
+// tokenErrorReason returns reason of token authentication error, empty for other errors like one of revocation list.
+func tokenErrorReason(err error) string {
+	for _, r := range tokenErrorReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return ""
+}
+
+// AuthInterceptor authenticates token of every request carrying one with sessions and rejects the request with ErrAuth
+// if the token is invalid or revoked, with reason detail telling why, like "expired".
+// Id of the user and of the session are passed to handler in ctx, see UserIDFromContext and SessionIDFromContext.
+// Requests without token, like LoginRequest, are passed through.
+func AuthInterceptor(sessions session.Manager, logger *zap.Logger) UnaryServerInterceptor {
+	return func(ctx context.Context, req proto.Message, info *UnaryServerInfo, handler UnaryHandler) (proto.Message, error) {
+		r, ok := req.(tokenRequest)
+		if !ok {
+			return handler(ctx, req)
+		}
+		id, sessionID, err := sessions.Authenticate(ctx, r.GetToken())
+		if err != nil {
+			logger.Warn("Token authentication failed", zap.String("remote", remoteAddr(ctx)), zap.String("method", info.FullMethod), zap.String("error", err.Error()))
+			if reason := tokenErrorReason(err); reason != "" {
+				return nil, ErrAuth.WithDetail("reason", reason)
+			}
+			return nil, ErrAuth
+		}
+		ctx = context.WithValue(ctx, userIDKey{}, id)
+		return handler(context.WithValue(ctx, sessionIDKey{}, sessionID), req)
+	}
+}
+
+// ErrRateLimited is returned to requests rejected by RateLimitInterceptor.
+var ErrRateLimited = &Error{Code: ErrorCode_RATE_LIMITED, Message: "Too many requests"}
+
+// deviceRequest is implemented by request messages reporting device of the user, like LoginRequest.
+type deviceRequest interface {
+	GetDevice() *Device
+}
+
+// rateLimitKey returns the client request is counted against: IP address of the user's device reported by web tier,
+// or the host of the connection otherwise.
+func rateLimitKey(ctx context.Context, req proto.Message) string {
+	if r, ok := req.(deviceRequest); ok && r.GetDevice().GetRemoteIp() != "" {
+		return r.GetDevice().GetRemoteIp()
+	}
+	addr := remoteAddr(ctx)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// RateLimitInterceptor allows each client at most rate requests per second to each method, with bursts of up to
+// burst requests. Client is the user's device if the request reports it, or the peer of the connection, see
+// rateLimitKey. It should run before AuthInterceptor so that requests with invalid tokens are limited too.
+// Requests over the limit are rejected with ErrRateLimited rather than queued.
+func RateLimitInterceptor(rate float64, burst int) UnaryServerInterceptor {
+	var mutex sync.Mutex
+	buckets := make(map[string]*tokenBucket)
+	lastSweep := time.Now()
+	return func(ctx context.Context, req proto.Message, info *UnaryServerInfo, handler UnaryHandler) (proto.Message, error) {
+		key := info.FullMethod + " " + rateLimitKey(ctx, req)
+		now := time.Now()
+		mutex.Lock()
+		// buckets of clients gone for a while are full again, they are dropped to keep the map small
+		if now.Sub(lastSweep) > time.Minute {
+			for k, b := range buckets {
+				if b.full(rate, float64(burst), now) {
+					delete(buckets, k)
+				}
+			}
+			lastSweep = now
+		}
+		b, ok := buckets[key]
+		if !ok {
+			b = &tokenBucket{tokens: float64(burst), last: now}
+			buckets[key] = b
+		}
+		allowed := b.take(rate, float64(burst), now)
+		mutex.Unlock()
+		if !allowed {
+			return nil, ErrRateLimited
+		}
+		return handler(ctx, req)
+	}
+}
+
+// tokenBucket is filled with rate tokens per second up to burst, and each request takes one token.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// full reports whether bucket is filled up to burst at now.
+func (b *tokenBucket) full(rate, burst float64, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*rate >= burst
+}
+
+// take takes a token at now, false if there is none left.
+func (b *tokenBucket) take(rate, burst float64, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
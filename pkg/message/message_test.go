@@ -1,19 +1,37 @@
 package message
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
+	"git.garena.com/youngiek.song/entry_task/internal/models"
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestMsgStreamHealthCheck(t *testing.T) {
 	client, server := net.Pipe()
-	clientStream, _ := NewMsgStream(server, 60*time.Second)
-	serverStream, _ := NewMsgStream(client, 60*time.Second)
+	clientStream, _ := NewMsgStream(server, StreamConfig{})
+	serverStream, _ := NewMsgStream(client, StreamConfig{})
 	done := make(chan bool)
 	go func() {
-		clientStream.WriteMsg(&HealthcheckMessage{})
-		resMsg, _ := clientStream.ReadMsg()
+		clientStream.WriteMsg(1, &HealthcheckMessage{})
+		_, resMsg, _ := clientStream.ReadMsg()
 		_, ok := resMsg.(*HealthcheckMessage)
 		if !ok {
 			t.Fail()
@@ -21,27 +39,27 @@ func TestMsgStreamHealthCheck(t *testing.T) {
 		done <- true
 	}()
 	go func() {
-		msg, _ := serverStream.ReadMsg()
+		_, msg, _ := serverStream.ReadMsg()
 		_, ok := msg.(*HealthcheckMessage)
 		if !ok {
 			t.Fail()
 		}
-		serverStream.WriteMsg(&HealthcheckMessage{})
+		serverStream.WriteMsg(1, &HealthcheckMessage{})
 	}()
 	<-done
 }
 
 func TestMsgStreamLogin(t *testing.T) {
 	client, server := net.Pipe()
-	clientStream, _ := NewMsgStream(server, 60*time.Second)
-	serverStream, _ := NewMsgStream(client, 60*time.Second)
+	clientStream, _ := NewMsgStream(server, StreamConfig{})
+	serverStream, _ := NewMsgStream(client, StreamConfig{})
 	done := make(chan bool)
 	go func() {
-		clientStream.WriteMsg(&LoginRequest{
+		clientStream.WriteMsg(1, &LoginRequest{
 			Id:       "hoiek12",
 			Password: "abc",
 		})
-		resMsg, _ := clientStream.ReadMsg()
+		_, resMsg, _ := clientStream.ReadMsg()
 		logRes, ok := resMsg.(*LoginResponse)
 		if !ok {
 			t.Fail()
@@ -53,7 +71,7 @@ func TestMsgStreamLogin(t *testing.T) {
 		done <- true
 	}()
 	go func() {
-		msg, _ := serverStream.ReadMsg()
+		_, msg, _ := serverStream.ReadMsg()
 		logReq, ok := msg.(*LoginRequest)
 		if !ok {
 			t.Fail()
@@ -62,7 +80,7 @@ func TestMsgStreamLogin(t *testing.T) {
 		if logReq.Id != "hoiek12" || logReq.Password != "abc" {
 			t.Fail()
 		}
-		serverStream.WriteMsg(&LoginResponse{
+		serverStream.WriteMsg(1, &LoginResponse{
 			Token: "abcd",
 		})
 	}()
@@ -71,14 +89,14 @@ func TestMsgStreamLogin(t *testing.T) {
 
 func TestMsgStreamGetUserInfo(t *testing.T) {
 	client, server := net.Pipe()
-	clientStream, _ := NewMsgStream(server, 60*time.Second)
-	serverStream, _ := NewMsgStream(client, 60*time.Second)
+	clientStream, _ := NewMsgStream(server, StreamConfig{})
+	serverStream, _ := NewMsgStream(client, StreamConfig{})
 	done := make(chan bool)
 	go func() {
-		clientStream.WriteMsg(&GetUserInfoRequest{
+		clientStream.WriteMsg(1, &GetUserInfoRequest{
 			Token: "abcd",
 		})
-		resMsg, _ := clientStream.ReadMsg()
+		_, resMsg, _ := clientStream.ReadMsg()
 		getRes, ok := resMsg.(*GetUserInfoResponse)
 		if !ok {
 			t.Fail()
@@ -90,7 +108,7 @@ func TestMsgStreamGetUserInfo(t *testing.T) {
 		done <- true
 	}()
 	go func() {
-		msg, _ := serverStream.ReadMsg()
+		_, msg, _ := serverStream.ReadMsg()
 		getReq, ok := msg.(*GetUserInfoRequest)
 		if !ok {
 			t.Fail()
@@ -99,7 +117,7 @@ func TestMsgStreamGetUserInfo(t *testing.T) {
 		if getReq.Token != "abcd" {
 			t.Fail()
 		}
-		serverStream.WriteMsg(&GetUserInfoResponse{
+		serverStream.WriteMsg(1, &GetUserInfoResponse{
 			User: &User{
 				Nickname: "nick",
 			},
@@ -110,17 +128,17 @@ func TestMsgStreamGetUserInfo(t *testing.T) {
 
 func TestMsgStreamEditUserInfo(t *testing.T) {
 	client, server := net.Pipe()
-	clientStream, _ := NewMsgStream(server, 60*time.Second)
-	serverStream, _ := NewMsgStream(client, 60*time.Second)
+	clientStream, _ := NewMsgStream(server, StreamConfig{})
+	serverStream, _ := NewMsgStream(client, StreamConfig{})
 	done := make(chan bool)
 	go func() {
-		clientStream.WriteMsg(&EditUserInfoRequest{
+		clientStream.WriteMsg(1, &EditUserInfoRequest{
 			Token: "abcd",
 			User: &User{
 				Nickname: "john",
 			},
 		})
-		resMsg, _ := clientStream.ReadMsg()
+		_, resMsg, _ := clientStream.ReadMsg()
 		res, ok := resMsg.(*Response)
 		if !ok {
 			t.Fail()
@@ -132,7 +150,7 @@ func TestMsgStreamEditUserInfo(t *testing.T) {
 		done <- true
 	}()
 	go func() {
-		msg, _ := serverStream.ReadMsg()
+		_, msg, _ := serverStream.ReadMsg()
 		editReq, ok := msg.(*EditUserInfoRequest)
 		if !ok {
 			t.Fail()
@@ -141,7 +159,7 @@ func TestMsgStreamEditUserInfo(t *testing.T) {
 		if editReq.Token != "abcd" || editReq.User.Nickname != "john" {
 			t.Fail()
 		}
-		serverStream.WriteMsg(&Response{
+		serverStream.WriteMsg(1, &Response{
 			Code: 0,
 		})
 	}()
@@ -150,14 +168,14 @@ func TestMsgStreamEditUserInfo(t *testing.T) {
 
 func TestMsgStreamAuthenticate(t *testing.T) {
 	client, server := net.Pipe()
-	clientStream, _ := NewMsgStream(server, 60*time.Second)
-	serverStream, _ := NewMsgStream(client, 60*time.Second)
+	clientStream, _ := NewMsgStream(server, StreamConfig{})
+	serverStream, _ := NewMsgStream(client, StreamConfig{})
 	done := make(chan bool)
 	go func() {
-		clientStream.WriteMsg(&AuthRequest{
+		clientStream.WriteMsg(1, &AuthRequest{
 			Token: "abcd",
 		})
-		resMsg, _ := clientStream.ReadMsg()
+		_, resMsg, _ := clientStream.ReadMsg()
 		res, ok := resMsg.(*Response)
 		if !ok {
 			t.Fail()
@@ -169,7 +187,7 @@ func TestMsgStreamAuthenticate(t *testing.T) {
 		done <- true
 	}()
 	go func() {
-		msg, _ := serverStream.ReadMsg()
+		_, msg, _ := serverStream.ReadMsg()
 		authReq, ok := msg.(*AuthRequest)
 		if !ok {
 			t.Fail()
@@ -178,9 +196,1323 @@ func TestMsgStreamAuthenticate(t *testing.T) {
 		if authReq.Token != "abcd" {
 			t.Fail()
 		}
-		serverStream.WriteMsg(&Response{
+		serverStream.WriteMsg(1, &Response{
 			Code: 0,
 		})
 	}()
 	<-done
 }
+
+func TestMsgStreamRequestID(t *testing.T) {
+	client, server := net.Pipe()
+	clientStream, _ := NewMsgStream(server, StreamConfig{})
+	serverStream, _ := NewMsgStream(client, StreamConfig{})
+	go clientStream.WriteMsg(300, &AuthRequest{Token: "abcd"})
+	reqID, msg, err := serverStream.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqID != 300 {
+		t.Errorf("request id: got %d, want 300", reqID)
+	}
+	if authReq, ok := msg.(*AuthRequest); !ok || authReq.Token != "abcd" {
+		t.Fail()
+	}
+}
+
+func TestMuxStreamOutOfOrder(t *testing.T) {
+	client, server := net.Pipe()
+	clientStream, _ := NewMsgStream(client, StreamConfig{})
+	serverStream, _ := NewMsgStream(server, StreamConfig{})
+	mux := NewMuxStream(clientStream)
+	defer mux.Close()
+	const calls = 10
+	go func() {
+		// receive every request first, then answer them in reverse order
+		ids := make([]uint64, calls)
+		tokens := make([]string, calls)
+		for i := 0; i < calls; i++ {
+			reqID, msg, err := serverStream.ReadMsg()
+			if err != nil {
+				return
+			}
+			ids[i] = reqID
+			tokens[i] = msg.(*AuthRequest).Token
+		}
+		for i := calls - 1; i >= 0; i-- {
+			serverStream.WriteMsg(ids[i], &LoginResponse{Token: tokens[i]})
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			res, err := mux.Call(context.Background(), &AuthRequest{Token: token})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if got := res.(*LoginResponse).Token; got != token {
+				t.Errorf("response mismatch: got %s, want %s", got, token)
+			}
+		}(fmt.Sprintf("token%d", i))
+	}
+	wg.Wait()
+}
+
+func TestMuxStreamBroken(t *testing.T) {
+	client, server := net.Pipe()
+	clientStream, _ := NewMsgStream(client, StreamConfig{})
+	mux := NewMuxStream(clientStream)
+	go func() {
+		serverStream, _ := NewMsgStream(server, StreamConfig{})
+		serverStream.ReadMsg()
+		server.Close()
+	}()
+	_, err := mux.Call(context.Background(), &AuthRequest{Token: "abcd"})
+	if err == nil {
+		t.Fatal("call on broken stream should fail")
+	}
+	if mux.Err() == nil {
+		t.Fail()
+	}
+}
+
+func TestRegistryMsgNums(t *testing.T) {
+	// numbers are part of the wire format and must never change
+	want := map[proto.Message]uint{
+		&HealthcheckMessage{}:    0,
+		&LoginRequest{}:          1,
+		&GetUserInfoRequest{}:    2,
+		&EditUserInfoRequest{}:   3,
+		&AuthRequest{}:           4,
+		&Response{}:              5,
+		&LoginResponse{}:         6,
+		&GetUserInfoResponse{}:   7,
+		&ProtocolError{}:         8,
+		&Hello{}:                 9,
+		&GoAway{}:                10,
+		&CreateUserRequest{}:     11,
+		&ChangePasswordRequest{}: 12,
+		&PasswordResetRequest{}:  13,
+		&ResetPasswordRequest{}:  14,
+		&LogoutRequest{}:         15,
+		&RefreshTokenRequest{}:   16,
+		&RefreshTokenResponse{}:  17,
+		&PublicKeysRequest{}:     18,
+		&PublicKeysResponse{}:    19,
+		&ListSessionsRequest{}:   20,
+		&ListSessionsResponse{}:  21,
+		&RevokeSessionRequest{}:  22,
+	}
+	for msg, num := range want {
+		got, err := getMsgNum(msg)
+		if err != nil || got != num {
+			t.Errorf("%T: got %d, %v, want %d", msg, got, err, num)
+		}
+		container, err := getMsgContainer(num)
+		if err != nil || reflect.TypeOf(container) != reflect.TypeOf(msg) {
+			t.Errorf("container of %d: got %T, %v, want %T", num, container, err, msg)
+		}
+	}
+}
+
+func TestRegistryUnknownType(t *testing.T) {
+	if _, err := getMsgNum(&User{}); err == nil {
+		t.Error("message without msg_num should not have type number")
+	}
+	if _, err := getMsgContainer(1000); err == nil {
+		t.Error("unknown type number should not have container")
+	}
+}
+
+func TestRegistryDuplicateNum(t *testing.T) {
+	_, err := NewRegistry(File_common_proto, File_common_proto)
+	if err == nil {
+		t.Fatal("duplicate type number should be rejected")
+	}
+	t.Log(err)
+	// file conflicting with registered one adds none of it's messages
+	r, _ := NewRegistry(File_user_proto)
+	if err = r.Register(File_user_proto); err == nil {
+		t.Fatal("registering file twice should be rejected")
+	}
+	if _, err = r.MsgNum(&HealthcheckMessage{}); err == nil {
+		t.Error("message of other file should not be registered")
+	}
+}
+
+// startTestServer starts server of store listening on random local port and returns the port.
+func startTestServer(t *testing.T, store models.UserStore, tokenIssuer *jwt.TokenIssuer) string {
+	server := NewServer("127.0.0.1", "0", ServerConfig{}, store, tokenIssuer, zap.NewNop())
+	go server.Run()
+	t.Cleanup(func() { server.listener.Close() })
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	return port
+}
+
+// newTestServer starts server listening on random local port and returns client connected to it.
+func newTestServer(t *testing.T, store models.UserStore, tokenIssuer *jwt.TokenIssuer) *Client {
+	return NewClient("127.0.0.1", startTestServer(t, store, tokenIssuer), PoolConfig{MaxConn: 2, MaxCalls: 4})
+}
+
+func TestClientAuthenticate(t *testing.T) {
+	issuer := jwt.NewTokenIssuer("key", time.Hour)
+	client := newTestServer(t, models.NewMemoryStore(models.NewBcryptHasher(bcrypt.MinCost)), issuer)
+	if err := client.Authenticate(context.Background(), issuer.GenerateToken("id")); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+	err := client.Authenticate(context.Background(), "invalid")
+	if !errors.Is(err, ErrAuth) {
+		t.Errorf("invalid token: got %v, want ErrAuth", err)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	res, ok := errorResponse(&LoginResponse{}, ErrAuth).(*LoginResponse)
+	if !ok || res.Response.GetCode() != ErrorCode_AUTH_FAILED || res.Response.GetMessage() != ErrAuth.Message {
+		t.Errorf("got %v", res)
+	}
+	code, ok := errorResponse(&Response{}, ErrDB).(*Response)
+	if !ok || code.Code != ErrorCode_DB_ERROR {
+		t.Errorf("got %v", code)
+	}
+	// internal errors are not exposed to the client
+	code = errorResponse(&Response{}, fmt.Errorf("connection refused")).(*Response)
+	if code.Code != ErrorCode_UNKNOWN || code.Message != ErrUnknown.Message {
+		t.Errorf("got %v", code)
+	}
+}
+
+func TestErrorFromResponse(t *testing.T) {
+	sent := NewError(ErrorCode_INVALID_INPUT, "nickname too long").WithDetail("field", "nickname")
+	err := errorFromResponse(responseFromError(fmt.Errorf("edit user: %w", sent)))
+	if !errors.Is(err, ErrInput) || errors.Is(err, ErrAuth) {
+		t.Errorf("got %v, want ErrInput", err)
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Message != sent.Message || e.Details["field"] != "nickname" {
+		t.Errorf("got %+v", e)
+	}
+	if errorFromResponse(&Response{}) != nil {
+		t.Error("OK response should not be an error")
+	}
+	if !errors.Is(TimeoutError{}, context.DeadlineExceeded) {
+		t.Error("TimeoutError should match context.DeadlineExceeded")
+	}
+}
+
+func TestMuxStreamCallTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	clientStream, _ := NewMsgStream(client, StreamConfig{})
+	serverStream, _ := NewMsgStream(server, StreamConfig{})
+	mux := NewMuxStream(clientStream)
+	defer mux.Close()
+	go func() {
+		// never answer first request, answer second one
+		serverStream.ReadMsg()
+		reqID, _, _ := serverStream.ReadMsg()
+		serverStream.WriteMsg(reqID, &HealthcheckMessage{})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := mux.Call(ctx, &HealthcheckMessage{})
+	if _, ok := err.(TimeoutError); !ok {
+		t.Fatalf("got %v, want TimeoutError", err)
+	}
+	// stream is still usable after timeout waiting for response
+	if _, err = mux.Call(context.Background(), &HealthcheckMessage{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMsgStreamPoolWaitTimeout(t *testing.T) {
+	port := startTestServer(t, nil, nil)
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 1, MaxCalls: 1})
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pool is saturated, next caller waits until deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = pool.GetMsgStream(ctx); err == nil {
+		t.Fatal("saturated pool should not return stream")
+	} else if _, ok := err.(TimeoutError); !ok {
+		t.Fatalf("got %v, want TimeoutError", err)
+	}
+	pool.closeMsgStream(stream)
+	if stream, err = pool.GetMsgStream(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pool.destroyMsgStream(stream)
+}
+
+func TestMsgStreamPoolAllDraining(t *testing.T) {
+	port := startTestServer(t, nil, nil)
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 1, MaxCalls: 2})
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the only stream is draining with a call in flight, and no connection can be added
+	atomic.StoreInt32(&stream.goAway, 1)
+	if _, err = pool.GetMsgStream(context.Background()); err != ErrNoStream {
+		t.Fatalf("got %v, want ErrNoStream", err)
+	}
+	// draining stream is closed once it's call finishes, making room for new connection
+	pool.closeMsgStream(stream)
+	next, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.destroyMsgStream(next)
+	if next == stream {
+		t.Error("draining stream should not be handed out")
+	}
+}
+
+func TestMsgStreamPoolSlowConnect(t *testing.T) {
+	port := startTestServer(t, nil, nil)
+	// first connection is relayed to the server, later ones are accepted but never answered
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if n > 0 {
+				continue
+			}
+			backend, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", port))
+			if err != nil {
+				return
+			}
+			defer backend.Close()
+			go io.Copy(backend, conn)
+			go io.Copy(conn, backend)
+		}
+	}()
+	_, proxyPort, _ := net.SplitHostPort(listener.Addr().String())
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", proxyPort, PoolConfig{MaxConn: 2, MaxCalls: 1})
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the stream is busy, so the next caller opens a connection whose handshake never finishes
+	ctx, cancel := context.WithCancel(context.Background())
+	slow := make(chan error, 1)
+	go func() {
+		_, err := pool.GetMsgStream(ctx)
+		slow <- err
+	}()
+	for {
+		pool.mutex.Lock()
+		dials := pool.dials
+		pool.mutex.Unlock()
+		if dials == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// stream given back is handed out without waiting for the connection
+	pool.closeMsgStream(stream)
+	getCtx, getCancel := context.WithTimeout(context.Background(), time.Second)
+	defer getCancel()
+	next, err := pool.GetMsgStream(getCtx)
+	if err != nil || next != stream {
+		t.Fatalf("got %v, %v while other connection is being opened", next, err)
+	}
+	pool.closeMsgStream(next)
+	cancel()
+	if err = <-slow; !errors.Is(err, context.Canceled) {
+		t.Errorf("slow connection: got %v, want context.Canceled", err)
+	}
+}
+
+func TestMsgStreamIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	serverStream, _ := NewMsgStream(server, StreamConfig{IdleTimeout: 50 * time.Millisecond})
+	if _, _, err := serverStream.ReadMsg(); err != ErrIdleTimeout {
+		t.Fatalf("got %v, want ErrIdleTimeout", err)
+	}
+}
+
+func TestMsgStreamPoolRecycleIdle(t *testing.T) {
+	port := startTestServer(t, nil, nil)
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 1, MaxCalls: 1, MaxIdle: 20 * time.Millisecond})
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.closeMsgStream(stream)
+	time.Sleep(50 * time.Millisecond)
+	next, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.destroyMsgStream(next)
+	if next == stream {
+		t.Error("stream idle longer than MaxIdle should not be reused")
+	}
+	if stream.Err() == nil {
+		t.Error("recycled stream should be closed")
+	}
+}
+
+// rawFrame builds frame header with given type number, request id and data length followed by data.
+func rawFrame(typeNum, reqID, size uint64, data []byte) []byte {
+	var frame []byte
+	var tmp [binary.MaxVarintLen64]byte
+	for _, v := range []uint64{typeNum, reqID, size} {
+		n := binary.PutUvarint(tmp[:], v)
+		frame = append(frame, tmp[:n]...)
+	}
+	return append(frame, data...)
+}
+
+func TestMsgStreamFrameTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	serverStream, _ := NewMsgStream(server, StreamConfig{MaxFrameSize: 1024})
+	// only the header is sent, reader must not wait for or allocate the data
+	go client.Write(rawFrame(0, 1, 1<<40, nil))
+	if _, _, err := serverStream.ReadMsg(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("got %v, want ErrFrameTooLarge", err)
+	}
+	if err := serverStream.WriteMsg(1, &GetUserInfoResponse{User: &User{Nickname: string(make([]byte, 2048))}}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("got %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestMsgStreamTruncated(t *testing.T) {
+	client, server := net.Pipe()
+	serverStream, _ := NewMsgStream(server, StreamConfig{})
+	go func() {
+		client.Write(rawFrame(0, 1, 10, []byte{1, 2, 3}))
+		client.Close()
+	}()
+	if _, _, err := serverStream.ReadMsg(); err != ErrTruncated {
+		t.Fatalf("got %v, want ErrTruncated", err)
+	}
+}
+
+func TestMsgStreamUnknownMessageType(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	serverStream, _ := NewMsgStream(server, StreamConfig{})
+	go func() {
+		client.Write(rawFrame(1000, 1, 3, []byte{1, 2, 3}))
+		client.Write(rawFrame(0, 2, 0, nil))
+	}()
+	reqID, _, err := serverStream.ReadMsg()
+	if !errors.Is(err, ErrUnknownMessageType) || reqID != 1 {
+		t.Fatalf("got %d %v, want ErrUnknownMessageType", reqID, err)
+	}
+	// frame of unknown type is skipped, following message is read normally
+	reqID, msg, err := serverStream.ReadMsg()
+	if _, ok := msg.(*HealthcheckMessage); !ok || reqID != 2 || err != nil {
+		t.Fatalf("got %d %v %v", reqID, msg, err)
+	}
+}
+
+func TestServerRejectsFrame(t *testing.T) {
+	server := NewServer("127.0.0.1", "0", ServerConfig{Stream: StreamConfig{MaxFrameSize: 1024}}, nil, nil, zap.NewNop())
+	go server.Run()
+	defer server.listener.Close()
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(rawFrame(0, 1, 4096, nil))
+	stream, _ := NewMsgStream(conn, StreamConfig{})
+	reqID, msg, err := stream.ReadMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe, ok := msg.(*ProtocolError)
+	if !ok || reqID != 0 || pe.Code != ProtocolError_FRAME_TOO_LARGE {
+		t.Fatalf("got %d %v, want FRAME_TOO_LARGE protocol error", reqID, msg)
+	}
+	// connection is closed after the protocol error
+	if _, _, err = stream.ReadMsg(); err == nil {
+		t.Fatal("connection should be closed")
+	}
+}
+
+func TestMuxStreamRemoteProtocolError(t *testing.T) {
+	client, server := net.Pipe()
+	clientStream, _ := NewMsgStream(client, StreamConfig{})
+	serverStream, _ := NewMsgStream(server, StreamConfig{})
+	mux := NewMuxStream(clientStream)
+	go func() {
+		serverStream.ReadMsg()
+		serverStream.WriteMsg(0, &ProtocolError{Code: ProtocolError_UNKNOWN_MESSAGE_TYPE, Reason: "test"})
+	}()
+	_, err := mux.Call(context.Background(), &HealthcheckMessage{})
+	if !errors.Is(err, ErrUnknownMessageType) {
+		t.Fatalf("got %v, want ErrUnknownMessageType", err)
+	}
+	if _, ok := err.(RemoteProtocolError); !ok {
+		t.Fatalf("got %T, want RemoteProtocolError", err)
+	}
+}
+
+// dialTestServer opens raw stream to test server without handshake.
+func dialTestServer(t *testing.T) *MsgStream {
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", startTestServer(t, nil, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	stream, _ := NewMsgStream(conn, StreamConfig{})
+	return stream
+}
+
+func TestHandshakeNegotiation(t *testing.T) {
+	stream := dialTestServer(t)
+	stream.WriteMsg(0, &Hello{Version: ProtocolVersion + 1, Features: []string{"compression", FeatureMultiplexing}, Build: "newer"})
+	reqID, msg, err := stream.ReadMsg()
+	hello, ok := msg.(*Hello)
+	if err != nil || !ok || reqID != 0 {
+		t.Fatalf("got %d %v %v, want Hello", reqID, msg, err)
+	}
+	// server answers with the version and features both sides support
+	if hello.Version != ProtocolVersion || !reflect.DeepEqual(hello.Features, []string{FeatureMultiplexing}) {
+		t.Errorf("got %v", hello)
+	}
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	tests := []struct {
+		first proto.Message
+		code  ProtocolError_Code
+	}{
+		{&Hello{Version: MinProtocolVersion - 1}, ProtocolError_INCOMPATIBLE_VERSION},
+		{&HealthcheckMessage{}, ProtocolError_HANDSHAKE_REQUIRED},
+	}
+	for _, test := range tests {
+		stream := dialTestServer(t)
+		stream.WriteMsg(0, test.first)
+		_, msg, err := stream.ReadMsg()
+		pe, ok := msg.(*ProtocolError)
+		if err != nil || !ok || pe.Code != test.code {
+			t.Errorf("%T: got %v %v, want %v", test.first, msg, err, test.code)
+		}
+	}
+}
+
+func TestMsgStreamPoolHandshake(t *testing.T) {
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", startTestServer(t, nil, nil), PoolConfig{MaxConn: 1, MaxCalls: 4})
+	if _, ok := pool.Handshake(); ok {
+		t.Error("handshake before connecting")
+	}
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.destroyMsgStream(stream)
+	hs, ok := pool.Handshake()
+	if !ok || hs.Version != ProtocolVersion || !hs.HasFeature(FeatureMultiplexing) || hs.PeerBuild != Build {
+		t.Errorf("got %+v", hs)
+	}
+}
+
+// blockingHealth answers healthcheck only after release is closed.
+type blockingHealth struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h blockingHealth) Check(ctx context.Context, req *HealthcheckMessage) (*HealthcheckMessage, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return &HealthcheckMessage{}, nil
+}
+
+// startBlockingServer starts server whose healthcheck blocks, and returns pool connected to it.
+func startBlockingServer(t *testing.T) (*Server, blockingHealth, *MsgStreamPool) {
+	server := NewServer("127.0.0.1", "0", ServerConfig{}, nil, nil, zap.NewNop())
+	health := blockingHealth{started: make(chan struct{}, 1), release: make(chan struct{})}
+	RegisterHealthServer(server, health)
+	go server.Run()
+	t.Cleanup(func() { server.listener.Close() })
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	return server, health, NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 2, MaxCalls: 4})
+}
+
+func TestServerShutdownDrains(t *testing.T) {
+	server, health, pool := startBlockingServer(t)
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := stream.Call(context.Background(), &HealthcheckMessage{})
+		pool.closeMsgStream(stream)
+		result <- err
+	}()
+	<-health.started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	// stream told to go away is not handed out anymore, and new connection is refused
+	for !stream.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if s, err := pool.GetMsgStream(ctx); err == nil {
+		t.Errorf("got stream %p after shutdown, in-flight stream %p", s, stream)
+	}
+
+	// request in progress is answered, then client closes the stream and shutdown finishes
+	close(health.release)
+	if err = <-result; err != nil {
+		t.Errorf("in-flight call failed: %v", err)
+	}
+	if err = <-shutdown; err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+	if stream.Err() == nil {
+		t.Error("drained stream should be closed")
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	server, health, pool := startBlockingServer(t)
+	defer close(health.release)
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := stream.Call(context.Background(), &HealthcheckMessage{})
+		result <- err
+	}()
+	<-health.started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+	// connection is closed forcibly
+	if err = <-result; err == nil {
+		t.Error("call should fail after forced shutdown")
+	}
+}
+
+func TestChainUnaryInterceptors(t *testing.T) {
+	var order []string
+	trace := func(name string) UnaryServerInterceptor {
+		return func(ctx context.Context, req proto.Message, info *UnaryServerInfo, handler UnaryHandler) (proto.Message, error) {
+			order = append(order, name+" in")
+			res, err := handler(ctx, req)
+			order = append(order, name+" out")
+			return res, err
+		}
+	}
+	chain := ChainUnaryInterceptors(trace("first"), trace("second"))
+	_, err := chain(context.Background(), &HealthcheckMessage{}, &UnaryServerInfo{}, func(ctx context.Context, req proto.Message) (proto.Message, error) {
+		order = append(order, "handler")
+		return req, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[first in second in handler second out first out]"
+	if got := fmt.Sprint(order); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	issuer := jwt.NewTokenIssuer("key", time.Hour)
+	auth := AuthInterceptor(session.NewJWTManager(issuer, session.NewMemoryRecords(time.Hour)), zap.NewNop())
+	info := &UnaryServerInfo{FullMethod: "/message.UserService/GetUserInfo"}
+	var id string
+	handler := func(ctx context.Context, req proto.Message) (proto.Message, error) {
+		id, _ = UserIDFromContext(ctx)
+		return req, nil
+	}
+	if _, err := auth(context.Background(), &GetUserInfoRequest{Token: issuer.GenerateToken("song")}, info, handler); err != nil || id != "song" {
+		t.Errorf("valid token: got id %q, error %v", id, err)
+	}
+	id = ""
+	if _, err := auth(context.Background(), &GetUserInfoRequest{Token: "invalid"}, info, handler); !errors.Is(err, ErrAuth) || id != "" {
+		t.Errorf("invalid token: got id %q, error %v", id, err)
+	}
+	// requests without token are not authenticated
+	if _, err := auth(context.Background(), &LoginRequest{}, info, handler); err != nil {
+		t.Errorf("login: %v", err)
+	}
+	// tokens issued before revocation are rejected, and ones issued after are not
+	old := issuer.GenerateToken("song")
+	issuer.RevokeUser("song")
+	if _, err := auth(context.Background(), &GetUserInfoRequest{Token: old}, info, handler); !errors.Is(err, ErrAuth) {
+		t.Errorf("revoked token: got %v, want ErrAuth", err)
+	}
+	if _, err := auth(context.Background(), &GetUserInfoRequest{Token: issuer.GenerateToken("song")}, info, handler); err != nil {
+		t.Errorf("token issued after revocation: %v", err)
+	}
+}
+
+func TestAuthInterceptorReason(t *testing.T) {
+	issuer := jwt.NewTokenIssuer("key", time.Hour)
+	issuer.SetIssuer("backend", "web")
+	auth := AuthInterceptor(session.NewJWTManager(issuer, session.NewMemoryRecords(time.Hour)), zap.NewNop())
+	info := &UnaryServerInfo{FullMethod: "/message.UserService/GetUserInfo"}
+	handler := func(ctx context.Context, req proto.Message) (proto.Message, error) {
+		return req, nil
+	}
+	other := jwt.NewTokenIssuer("key", time.Hour)
+	other.SetIssuer("backend", "other")
+	revoked := issuer.GenerateToken("song")
+	issuer.RevokeToken(revoked)
+	tests := map[string]string{
+		jwt.NewTokenIssuer("key", -time.Minute).GenerateToken("song"): "expired",
+		jwt.NewTokenIssuer("wrong", time.Hour).GenerateToken("song"):  "invalid_signature",
+		other.GenerateToken("song"):                                   "invalid_audience",
+		revoked:                                                       "revoked",
+		"broken":                                                      "malformed",
+	}
+	for token, reason := range tests {
+		_, err := auth(context.Background(), &GetUserInfoRequest{Token: token}, info, handler)
+		var e *Error
+		if !errors.As(err, &e) || e.Code != ErrorCode_AUTH_FAILED || e.Details["reason"] != reason {
+			t.Errorf("got %#v, want reason %s", err, reason)
+		}
+	}
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	limit := RateLimitInterceptor(0, 2)
+	handler := func(ctx context.Context, req proto.Message) (proto.Message, error) { return req, nil }
+	login := &UnaryServerInfo{FullMethod: "/message.UserService/Login"}
+	for i := 0; i < 2; i++ {
+		if _, err := limit(context.Background(), &LoginRequest{}, login, handler); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if _, err := limit(context.Background(), &LoginRequest{}, login, handler); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, want ErrRateLimited", err)
+	}
+	// each method has its own limit
+	health := &UnaryServerInfo{FullMethod: "/message.Health/Check"}
+	if _, err := limit(context.Background(), &HealthcheckMessage{}, health, handler); err != nil {
+		t.Errorf("other method: %v", err)
+	}
+	// and each device reported by web tier too
+	other := &LoginRequest{Device: &Device{RemoteIp: "10.0.0.2"}}
+	if _, err := limit(context.Background(), other, login, handler); err != nil {
+		t.Errorf("other device: %v", err)
+	}
+}
+
+func TestRateLimitBeforeAuth(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{RateLimit: RateLimitConfig{Rate: 0.001, Burst: 2}})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := client.Authenticate(ctx, "invalid"); !errors.Is(err, ErrAuth) {
+			t.Fatalf("request %d: got %v, want ErrAuth", i, err)
+		}
+	}
+	// requests with invalid tokens are limited before they are authenticated
+	if err := client.Authenticate(ctx, "invalid"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, want ErrRateLimited", err)
+	}
+	// login of another device is not limited by them
+	device := &Device{RemoteIp: "10.0.0.2"}
+	if _, _, err := client.Login(ctx, "song", "passw0rd", device); err != nil {
+		t.Errorf("login of other device: %v", err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := &tokenBucket{tokens: 1, last: now}
+	if !b.take(10, 1, now) || b.take(10, 1, now) {
+		t.Fatal("bucket of one token should allow one request")
+	}
+	// refilled at 10 tokens per second, but never over burst
+	if !b.take(10, 1, now.Add(100*time.Millisecond)) {
+		t.Error("token should be refilled after 100ms")
+	}
+	if !b.take(10, 1, now.Add(time.Hour)) || b.take(10, 1, now.Add(time.Hour)) {
+		t.Error("bucket should not be filled over burst")
+	}
+	if b.full(10, 1, now.Add(time.Hour)) || !b.full(10, 1, now.Add(time.Hour+100*time.Millisecond)) {
+		t.Error("bucket should be full once refilled up to burst")
+	}
+}
+
+func TestServerInterceptors(t *testing.T) {
+	server := NewServer("127.0.0.1", "0", ServerConfig{}, nil, nil, zap.NewNop())
+	server.Use(func(ctx context.Context, req proto.Message, info *UnaryServerInfo, handler UnaryHandler) (proto.Message, error) {
+		if info.FullMethod == "/message.UserService/Login" {
+			return nil, ErrRateLimited
+		}
+		return handler(ctx, req)
+	})
+	go server.Run()
+	t.Cleanup(func() { server.listener.Close() })
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 1, MaxCalls: 1})
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.destroyMsgStream(stream)
+	if _, err = stream.Call(context.Background(), &HealthcheckMessage{}); err != nil {
+		t.Fatalf("health check: %v", err)
+	}
+	res, err := stream.Call(context.Background(), &LoginRequest{Id: "id", Password: "passwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := res.(*LoginResponse); !ok || r.GetResponse().GetCode() != ErrorCode_RATE_LIMITED {
+		t.Errorf("got %v, want RATE_LIMITED response", res)
+	}
+}
+
+func TestServerRecoversPanic(t *testing.T) {
+	// login with nil store panics in the handler
+	var panics []string
+	server := NewServer("127.0.0.1", "0", ServerConfig{OnPanic: func(method string) {
+		panics = append(panics, method)
+	}}, nil, nil, zap.NewNop())
+	go server.Run()
+	t.Cleanup(func() { server.listener.Close() })
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 1, MaxCalls: 1})
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.destroyMsgStream(stream)
+	res, err := stream.Call(context.Background(), &LoginRequest{Id: "id", Password: "passwd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = errorFromResponse(res.(*LoginResponse).GetResponse()); !errors.Is(err, ErrInternal) {
+		t.Errorf("got %v, want ErrInternal", err)
+	}
+	if len(panics) != 1 || panics[0] != "/message.UserService/Login" {
+		t.Errorf("got panics of %v, want one of Login", panics)
+	}
+	// connection is still usable
+	if _, err = stream.Call(context.Background(), &HealthcheckMessage{}); err != nil {
+		t.Errorf("call after panic: %v", err)
+	}
+}
+
+func TestEditUserInfoWithoutUser(t *testing.T) {
+	issuer := jwt.NewTokenIssuer("key", time.Hour)
+	client := newTestServer(t, models.NewMemoryStore(models.NewBcryptHasher(bcrypt.MinCost)), issuer)
+	err := client.EditUserInfo(context.Background(), issuer.GenerateToken("id"), nil)
+	if !errors.Is(err, ErrInput) {
+		t.Errorf("got %v, want ErrInput", err)
+	}
+}
+
+func TestClientUserInfo(t *testing.T) {
+	issuer := jwt.NewTokenIssuer("key", time.Hour)
+	store := models.NewMemoryStore(models.NewBcryptHasher(bcrypt.MinCost))
+	store.CreateUser(context.Background(), &models.User{Id: "song", Password: "passwd", Nickname: "young"})
+	client := newTestServer(t, store, issuer)
+	ctx := context.Background()
+
+	if _, _, err := client.Login(ctx, "song", "wrong", nil); !errors.Is(err, ErrAuth) {
+		t.Errorf("wrong password: got %v, want ErrAuth", err)
+	}
+	token, _, err := client.Login(ctx, "song", "passwd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.EditUserInfo(ctx, token, &User{Id: "song", Nickname: "iek"}); err != nil {
+		t.Fatal(err)
+	}
+	user, err := client.GetUserInfo(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != "song" || user.Nickname != "iek" {
+		t.Errorf("got %v", user)
+	}
+	// token of deleted user
+	store.DeleteUser(ctx, "song")
+	if _, err = client.GetUserInfo(ctx, token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted user: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestClientEditOtherUser(t *testing.T) {
+	issuer := jwt.NewTokenIssuer("key", time.Hour)
+	store := models.NewMemoryStore(models.NewBcryptHasher(bcrypt.MinCost))
+	store.CreateUser(context.Background(), &models.User{Id: "song", Password: "passwd", Nickname: "young"})
+	store.CreateUser(context.Background(), &models.User{Id: "other", Password: "passwd", Nickname: "other"})
+	client := newTestServer(t, store, issuer)
+	ctx := context.Background()
+	token, _, err := client.Login(ctx, "other", "passwd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.EditUserInfo(ctx, token, &User{Id: "song", Nickname: "hacked"}); !errors.Is(err, ErrDenied) {
+		t.Errorf("editing other user: got %v, want ErrDenied", err)
+	}
+	if user, _ := store.GetUser(ctx, "song"); user.Nickname != "young" {
+		t.Errorf("other user is edited: got %q", user.Nickname)
+	}
+	// without id, the user of the token is edited
+	if err = client.EditUserInfo(ctx, token, &User{Nickname: "renamed"}); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ := store.GetUser(ctx, "other"); user.Nickname != "renamed" {
+		t.Errorf("got %q, want renamed", user.Nickname)
+	}
+}
+
+func TestValidateNewUser(t *testing.T) {
+	tests := []struct {
+		req   *CreateUserRequest
+		field string // invalid field, empty if valid
+	}{
+		{&CreateUserRequest{Id: "song_01", Password: "passw0rd"}, ""},
+		{&CreateUserRequest{Id: "song", Password: "passw0rd", Nickname: "영익"}, ""},
+		{&CreateUserRequest{Id: "son", Password: "passw0rd"}, "id"},
+		{&CreateUserRequest{Id: "song'--", Password: "passw0rd"}, "id"},
+		{&CreateUserRequest{Id: "song", Password: "pa55"}, "password"},
+		{&CreateUserRequest{Id: "song", Password: "password"}, "password"},
+		{&CreateUserRequest{Id: "song", Password: "12345678"}, "password"},
+		{&CreateUserRequest{Id: "song1234", Password: "song1234"}, "password"},
+		{&CreateUserRequest{Id: "song", Password: "passw0rd", Nickname: strings.Repeat("n", 65)}, "nickname"},
+	}
+	for _, test := range tests {
+		err := validateNewUser(test.req)
+		var e *Error
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%v: %v", test.req, err)
+			}
+		} else if !errors.As(err, &e) || e.Code != ErrorCode_INVALID_INPUT || e.Details["field"] != test.field {
+			t.Errorf("%v: got %v, want invalid %s", test.req, err, test.field)
+		}
+	}
+}
+
+func TestClientCreateUser(t *testing.T) {
+	issuer := jwt.NewTokenIssuer("key", time.Hour)
+	client := newTestServer(t, models.NewMemoryStore(models.NewBcryptHasher(bcrypt.MinCost)), issuer)
+	ctx := context.Background()
+	if err := client.CreateUser(ctx, "newbie", "passw0rd", ""); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := client.Login(ctx, "newbie", "passw0rd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := client.GetUserInfo(ctx, token)
+	if err != nil || user.Nickname != "newbie" {
+		t.Errorf("got %v, %v", user, err)
+	}
+	if err = client.CreateUser(ctx, "newbie", "an0therpass", "other"); !errors.Is(err, ErrExists) {
+		t.Errorf("duplicate id: got %v, want ErrExists", err)
+	}
+	if err = client.CreateUser(ctx, "x", "passw0rd", ""); !errors.Is(err, ErrInput) {
+		t.Errorf("invalid id: got %v, want ErrInput", err)
+	}
+}
+
+// testNotifier keeps the last reset token sent.
+type testNotifier struct {
+	mutex sync.Mutex
+	id    string
+	token string
+}
+
+func (n *testNotifier) NotifyPasswordReset(ctx context.Context, id, token string, expires time.Time) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.id, n.token = id, token
+	return nil
+}
+
+func (n *testNotifier) last() (string, string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.id, n.token
+}
+
+// newPasswordTestServer starts server with user song of password "passw0rd" and returns client connected to it.
+func newPasswordTestServer(t *testing.T, config ServerConfig) (*Client, *jwt.TokenIssuer) {
+	issuer := jwt.NewTokenIssuer("key", time.Hour)
+	store := models.NewMemoryStore(models.NewBcryptHasher(bcrypt.MinCost))
+	store.CreateUser(context.Background(), &models.User{Id: "song", Password: "passw0rd"})
+	server := NewServer("127.0.0.1", "0", config, store, issuer, zap.NewNop())
+	go server.Run()
+	t.Cleanup(func() { server.listener.Close() })
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	return NewClient("127.0.0.1", port, PoolConfig{MaxConn: 2, MaxCalls: 4}), issuer
+}
+
+func TestClientChangePassword(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{})
+	ctx := context.Background()
+	token, _, err := client.Login(ctx, "song", "passw0rd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := client.Login(ctx, "song", "passw0rd", nil) // session on another device
+	if err = client.ChangePassword(ctx, token, "wrong", "newpassw0rd"); !errors.Is(err, ErrAuth) {
+		t.Errorf("wrong current password: got %v, want ErrAuth", err)
+	}
+	if err = client.ChangePassword(ctx, token, "passw0rd", "short1"); !errors.Is(err, ErrInput) {
+		t.Errorf("invalid new password: got %v, want ErrInput", err)
+	}
+	if err = client.ChangePassword(ctx, token, "passw0rd", "newpassw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	// every session is revoked
+	for _, tok := range []string{token, other} {
+		if err = client.Authenticate(ctx, tok); !errors.Is(err, ErrAuth) {
+			t.Errorf("session after password change: got %v, want ErrAuth", err)
+		}
+	}
+	if _, _, err = client.Login(ctx, "song", "passw0rd", nil); !errors.Is(err, ErrAuth) {
+		t.Errorf("old password: got %v, want ErrAuth", err)
+	}
+	if token, _, err = client.Login(ctx, "song", "newpassw0rd", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Authenticate(ctx, token); err != nil {
+		t.Errorf("new session: %v", err)
+	}
+}
+
+func TestClientChangePasswordRevokesResetTokens(t *testing.T) {
+	notifier := &testNotifier{}
+	client, _ := newPasswordTestServer(t, ServerConfig{Notifier: notifier})
+	ctx := context.Background()
+	if err := client.RequestPasswordReset(ctx, "song"); err != nil {
+		t.Fatal(err)
+	}
+	_, reset := notifier.last()
+	token, _, err := client.Login(ctx, "song", "passw0rd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.ChangePassword(ctx, token, "passw0rd", "newpassw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	// link sent before the change doesn't work anymore
+	if err = client.ResetPassword(ctx, reset, "an0therpass"); !errors.Is(err, ErrAuth) {
+		t.Errorf("reset token after password change: got %v, want ErrAuth", err)
+	}
+}
+
+func TestClientResetPassword(t *testing.T) {
+	notifier := &testNotifier{}
+	client, _ := newPasswordTestServer(t, ServerConfig{Notifier: notifier})
+	ctx := context.Background()
+	session, _, _ := client.Login(ctx, "song", "passw0rd", nil)
+
+	// unknown user gets no token, and can't be told apart
+	if err := client.RequestPasswordReset(ctx, "nobody"); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := notifier.last(); id != "" {
+		t.Errorf("token sent to unknown user %q", id)
+	}
+	if err := client.RequestPasswordReset(ctx, "song"); err != nil {
+		t.Fatal(err)
+	}
+	_, earlier := notifier.last()
+	if err := client.RequestPasswordReset(ctx, "song"); err != nil {
+		t.Fatal(err)
+	}
+	id, token := notifier.last()
+	if id != "song" || token == "" || token == earlier {
+		t.Fatalf("got token %q of %q", token, id)
+	}
+	if err := client.ResetPassword(ctx, "forged", "newpassw0rd"); !errors.Is(err, ErrAuth) {
+		t.Errorf("unknown token: got %v, want ErrAuth", err)
+	}
+	if err := client.ResetPassword(ctx, token, "newpassw0rd"); err != nil {
+		t.Fatal(err)
+	}
+	// token is single-use, and other tokens of the user are revoked by the reset
+	if err := client.ResetPassword(ctx, token, "an0therpass"); !errors.Is(err, ErrAuth) {
+		t.Errorf("used token: got %v, want ErrAuth", err)
+	}
+	if err := client.ResetPassword(ctx, earlier, "an0therpass"); !errors.Is(err, ErrAuth) {
+		t.Errorf("token issued before reset: got %v, want ErrAuth", err)
+	}
+	if err := client.Authenticate(ctx, session); !errors.Is(err, ErrAuth) {
+		t.Errorf("session after reset: got %v, want ErrAuth", err)
+	}
+	if _, _, err := client.Login(ctx, "song", "newpassw0rd", nil); err != nil {
+		t.Errorf("login with new password: %v", err)
+	}
+}
+
+func TestClientLogout(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{})
+	ctx := context.Background()
+	var tokens []string
+	for i := 0; i < 3; i++ {
+		token, _, err := client.Login(ctx, "song", "passw0rd", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	// only the token logged out is revoked
+	if err := client.Logout(ctx, tokens[0], "", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Authenticate(ctx, tokens[0]); !errors.Is(err, ErrAuth) {
+		t.Errorf("logged out token: got %v, want ErrAuth", err)
+	}
+	if err := client.Authenticate(ctx, tokens[1]); err != nil {
+		t.Errorf("other token: %v", err)
+	}
+	if err := client.Logout(ctx, tokens[0], "", false); !errors.Is(err, ErrAuth) {
+		t.Errorf("logout twice: got %v, want ErrAuth", err)
+	}
+	// all devices
+	if err := client.Logout(ctx, tokens[1], "", true); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens[1:] {
+		if err := client.Authenticate(ctx, token); !errors.Is(err, ErrAuth) {
+			t.Errorf("token after logout of all devices: got %v, want ErrAuth", err)
+		}
+	}
+	token, _, _ := client.Login(ctx, "song", "passw0rd", nil)
+	if err := client.Authenticate(ctx, token); err != nil {
+		t.Errorf("new login: %v", err)
+	}
+}
+
+func TestClientRefreshToken(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{RefreshTokens: session.NewMemoryRefreshTokens(time.Hour, 0)})
+	ctx := context.Background()
+	_, refresh, err := client.Login(ctx, "song", "passw0rd", nil)
+	if err != nil || refresh == "" {
+		t.Fatalf("got refresh token %q, %v", refresh, err)
+	}
+	token, next, err := client.RefreshToken(ctx, refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == refresh {
+		t.Error("refresh token should be rotated")
+	}
+	if err = client.Authenticate(ctx, token); err != nil {
+		t.Errorf("refreshed access token: %v", err)
+	}
+	if _, _, err = client.RefreshToken(ctx, "forged"); !errors.Is(err, ErrAuth) {
+		t.Errorf("unknown token: got %v, want ErrAuth", err)
+	}
+
+	// replaying used token revokes the family, including the token rotated from it
+	_, other, _ := client.Login(ctx, "song", "passw0rd", nil) // login on another device
+	if _, _, err = client.RefreshToken(ctx, refresh); !errors.Is(err, ErrAuth) {
+		t.Errorf("reused token: got %v, want ErrAuth", err)
+	}
+	if _, _, err = client.RefreshToken(ctx, next); !errors.Is(err, ErrAuth) {
+		t.Errorf("token of revoked family: got %v, want ErrAuth", err)
+	}
+	if _, _, err = client.RefreshToken(ctx, other); err != nil {
+		t.Errorf("token of other login: %v", err)
+	}
+}
+
+func TestClientConcurrentRefreshToken(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{})
+	ctx := context.Background()
+	_, refresh, _ := client.Login(ctx, "song", "passw0rd", nil)
+	// two tabs refreshing with the same token within session.RefreshGrace both get tokens
+	_, first, err := client.RefreshToken(ctx, refresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := client.RefreshToken(ctx, refresh)
+	if err != nil {
+		t.Fatalf("refresh within grace time: %v", err)
+	}
+	if first == second {
+		t.Error("refresh within grace time should get another token")
+	}
+	for _, token := range []string{first, second} {
+		if _, _, err = client.RefreshToken(ctx, token); err != nil {
+			t.Errorf("token rotated within grace time: %v", err)
+		}
+	}
+}
+
+func TestClientLogoutRevokesRefreshToken(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{})
+	ctx := context.Background()
+	token, refresh, _ := client.Login(ctx, "song", "passw0rd", nil)
+	_, other, _ := client.Login(ctx, "song", "passw0rd", nil)
+	if err := client.Logout(ctx, token, refresh, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.RefreshToken(ctx, refresh); !errors.Is(err, ErrAuth) {
+		t.Errorf("refresh after logout: got %v, want ErrAuth", err)
+	}
+	token, other, err := client.RefreshToken(ctx, other)
+	if err != nil {
+		t.Fatalf("refresh token of other login: %v", err)
+	}
+	// logout of all devices and password change revoke refresh tokens of every login
+	if err = client.Logout(ctx, token, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = client.RefreshToken(ctx, other); !errors.Is(err, ErrAuth) {
+		t.Errorf("refresh after logout of all devices: got %v, want ErrAuth", err)
+	}
+}
+
+func TestClientPublicKeys(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := jwt.NewKeySetTokenIssuer(jwt.NewStaticKeySet(jwt.Key{ID: "ed", Algorithm: jwt.AlgEdDSA, PrivateKey: private}), time.Hour)
+	store := models.NewMemoryStore(models.NewBcryptHasher(bcrypt.MinCost))
+	store.CreateUser(context.Background(), &models.User{Id: "song", Password: "passw0rd"})
+	client := newTestServer(t, store, issuer)
+	ctx := context.Background()
+
+	// token is verified with keys fetched from the server, without asking it
+	verifier := jwt.NewTokenVerifier(jwt.NewRemoteKeySet(func() ([]byte, error) {
+		return client.PublicKeys(ctx)
+	}, time.Hour))
+	token, _, err := client.Login(ctx, "song", "passw0rd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := verifier.AuthenticateToken(token); id != "song" || err != nil {
+		t.Errorf("got %q, %v", id, err)
+	}
+}
+
+func TestClientSessionStore(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{Sessions: session.NewMemoryStore(time.Hour)})
+	ctx := context.Background()
+	token, _, err := client.Login(ctx, "song", "passw0rd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(token, ".") != 0 {
+		t.Errorf("got JWT %q, want opaque session id", token)
+	}
+	// requests with session id work same as with JWT
+	if err = client.Authenticate(ctx, token); err != nil {
+		t.Errorf("authenticate: %v", err)
+	}
+	if err = client.EditUserInfo(ctx, token, &User{Id: "song", Nickname: "young"}); err != nil {
+		t.Errorf("edit user info: %v", err)
+	}
+	if user, err := client.GetUserInfo(ctx, token); err != nil || user.Nickname != "young" {
+		t.Errorf("get user info: got %v, %v", user, err)
+	}
+	other, _, _ := client.Login(ctx, "song", "passw0rd", nil)
+
+	// logout revokes the session at once
+	if err = client.Logout(ctx, token, "", false); err != nil {
+		t.Fatal(err)
+	}
+	var e *Error
+	if _, err = client.GetUserInfo(ctx, token); !errors.As(err, &e) || e.Details["reason"] != "invalid_session" {
+		t.Errorf("logged out session: got %v, want invalid_session", err)
+	}
+	if err = client.Authenticate(ctx, other); err != nil {
+		t.Errorf("other session: %v", err)
+	}
+	if err = client.Logout(ctx, other, "", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Authenticate(ctx, other); !errors.Is(err, ErrAuth) {
+		t.Errorf("session of user logged out of all devices: got %v, want ErrAuth", err)
+	}
+}
+
+func TestClientSessions(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{})
+	ctx := context.Background()
+	token, refresh, err := client.Login(ctx, "song", "passw0rd", &Device{UserAgent: "phone", RemoteIp: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := client.Login(ctx, "song", "passw0rd", &Device{UserAgent: "laptop", RemoteIp: "10.0.0.2"})
+	sessions, err := client.ListSessions(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(sessions))
+	}
+	var phone *Session
+	for _, s := range sessions {
+		if s.Device.GetUserAgent() == "phone" {
+			phone = s
+		}
+	}
+	if phone == nil || !phone.Current || phone.Device.RemoteIp != "10.0.0.1" || phone.Created == 0 {
+		t.Fatalf("session of the request: got %v", phone)
+	}
+
+	// signing the phone out from the laptop revokes it's access and refresh tokens
+	if err = client.RevokeSession(ctx, other, phone.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Authenticate(ctx, token); !errors.Is(err, ErrAuth) {
+		t.Errorf("token of revoked session: got %v, want ErrAuth", err)
+	}
+	if _, _, err = client.RefreshToken(ctx, refresh); !errors.Is(err, ErrAuth) {
+		t.Errorf("refresh token of revoked session: got %v, want ErrAuth", err)
+	}
+	if sessions, _ = client.ListSessions(ctx, other); len(sessions) != 1 || sessions[0].Device.UserAgent != "laptop" {
+		t.Errorf("sessions after revoke: got %v", sessions)
+	}
+	if err = client.RevokeSession(ctx, other, ""); !errors.Is(err, ErrInput) {
+		t.Errorf("empty session id: got %v, want ErrInput", err)
+	}
+}
+
+func TestClientRevokeSessionOfOtherUser(t *testing.T) {
+	client, _ := newPasswordTestServer(t, ServerConfig{Sessions: session.NewMemoryStore(time.Hour)})
+	ctx := context.Background()
+	client.CreateUser(ctx, "other", "passw0rd", "")
+	token, _, _ := client.Login(ctx, "song", "passw0rd", nil)
+	sessions, err := client.ListSessions(ctx, token)
+	if err != nil || len(sessions) != 1 || !sessions[0].Current {
+		t.Fatalf("got %v, %v", sessions, err)
+	}
+	intruder, _, _ := client.Login(ctx, "other", "passw0rd", nil)
+	if err = client.RevokeSession(ctx, intruder, sessions[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.Authenticate(ctx, token); err != nil {
+		t.Errorf("session revoked by other user: %v", err)
+	}
+}
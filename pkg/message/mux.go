@@ -0,0 +1,197 @@
+package message
+
+import (
+	"context"
+	"errors"
+	sync "sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrStreamClosed is returned to callers waiting on a MuxStream which has been closed.
+var ErrStreamClosed = errors.New("message stream closed")
+
+// MuxStream multiplexes many concurrent calls over a single MsgStream.
+// Every request written by Call is tagged with an unique request id, and a background reader
+// delivers each response to the caller waiting for the same id. Responses may arrive in any order.
+// Once reading or writing fails, the stream is broken and every pending call returns the error.
+type MuxStream struct {
+	stream   *MsgStream                    // underlying message stream
+	mutex    sync.Mutex                    // protects pending, nextID and err
+	pending  map[uint64]chan proto.Message // callers waiting for response, keyed by request id
+	nextID   uint64                        // last request id issued, request id 0 is never used
+	calls    int32                         // number of calls currently using this stream, managed by MsgStreamPool
+	used     int64                         // last time the stream was taken or given back to MsgStreamPool, in unix nano
+	err      error                         // error which broke the stream
+	hs       Handshake                     // result of handshake done on the stream before multiplexing
+	done     chan struct{}                 // closed when the stream is broken
+	goAway   int32                         // set to 1 when server sent GoAway, no more call should be made
+	onGoAway func()                        // called when GoAway is received
+}
+
+// NewMuxStream create new MuxStream on top of message stream and start reading responses from it.
+func NewMuxStream(stream *MsgStream) *MuxStream {
+	return newMuxStream(stream, Handshake{}, nil)
+}
+
+// newMuxStream create MuxStream on stream whose handshake is done, onGoAway is called when server sends GoAway.
+func newMuxStream(stream *MsgStream, hs Handshake, onGoAway func()) *MuxStream {
+	ms := &MuxStream{
+		stream:   stream,
+		pending:  make(map[uint64]chan proto.Message),
+		done:     make(chan struct{}),
+		hs:       hs,
+		onGoAway: onGoAway,
+	}
+	go ms.readLoop()
+	return ms
+}
+
+// Call write request to the stream and wait for it's response.
+// It is safe to call Call from multiple goroutines, calls are pipelined over the stream.
+// Deadline and cancellation of ctx are honored while writing the request and waiting for the response.
+// If ctx is done while waiting for the response, the stream is still usable and the late response is dropped.
+func (ms *MuxStream) Call(ctx context.Context, req proto.Message) (proto.Message, error) {
+	if ctx.Err() != nil {
+		return nil, contextError(ctx)
+	}
+	resCh := make(chan proto.Message, 1)
+	ms.mutex.Lock()
+	if ms.err != nil {
+		ms.mutex.Unlock()
+		return nil, ms.err
+	}
+	ms.nextID++
+	reqID := ms.nextID
+	ms.pending[reqID] = resCh
+	ms.mutex.Unlock()
+
+	frame, err := ms.stream.encodeMsg(reqID, req)
+	if err != nil {
+		// invalid request does not break the stream
+		ms.forget(reqID)
+		return nil, err
+	}
+	err = ms.stream.writeFrame(ctx, frame)
+	if err != nil {
+		if err == ctx.Err() {
+			// nothing has been written yet, stream is still usable
+			ms.forget(reqID)
+			return nil, contextError(ctx)
+		}
+		ms.fail(err)
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return nil, err
+	}
+	select {
+	case res := <-resCh:
+		return res, nil
+	case <-ms.done:
+		return nil, ms.Err()
+	case <-ctx.Done():
+		ms.forget(reqID)
+		return nil, contextError(ctx)
+	}
+}
+
+// forget stop waiting for response of request, response arriving later is dropped.
+func (ms *MuxStream) forget(reqID uint64) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	delete(ms.pending, reqID)
+}
+
+// readLoop read responses from the stream and hand them over to waiting callers until the stream breaks.
+func (ms *MuxStream) readLoop() {
+	for {
+		reqID, msg, err := ms.stream.ReadMsg()
+		if err != nil {
+			ms.fail(err)
+			return
+		}
+		// server tells why it is closing the connection on request id 0
+		if pe, ok := msg.(*ProtocolError); ok && reqID == 0 {
+			ms.fail(RemoteProtocolError{Code: pe.Code, Reason: pe.Reason})
+			return
+		}
+		// pending calls are still answered after GoAway
+		if _, ok := msg.(*GoAway); ok && reqID == 0 {
+			atomic.StoreInt32(&ms.goAway, 1)
+			if ms.onGoAway != nil {
+				ms.onGoAway()
+			}
+			continue
+		}
+		ms.mutex.Lock()
+		resCh, ok := ms.pending[reqID]
+		delete(ms.pending, reqID)
+		ms.mutex.Unlock()
+		// response to unknown request is just dropped
+		if ok {
+			resCh <- msg
+		}
+	}
+}
+
+// fail mark the stream broken with err, close it and wake up every pending caller.
+func (ms *MuxStream) fail(err error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.err != nil {
+		return
+	}
+	ms.err = err
+	ms.pending = nil
+	ms.stream.Close()
+	close(ms.done)
+}
+
+// Close closes the stream, pending calls return ErrStreamClosed.
+func (ms *MuxStream) Close() {
+	ms.fail(ErrStreamClosed)
+}
+
+// Err returns error which broke the stream, nil if stream is still usable.
+func (ms *MuxStream) Err() error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.err
+}
+
+// Draining reports whether server asked to stop sending requests on the stream with GoAway.
+func (ms *MuxStream) Draining() bool {
+	return atomic.LoadInt32(&ms.goAway) == 1
+}
+
+// Handshake returns negotiated version and features of the stream.
+func (ms *MuxStream) Handshake() Handshake {
+	return ms.hs
+}
+
+// maxCalls returns number of calls the stream can carry at the same time, which is limit
+// unless the server handles requests one by one.
+func (ms *MuxStream) maxCalls(limit int32) int32 {
+	if !ms.hs.HasFeature(FeatureMultiplexing) {
+		return 1
+	}
+	return limit
+}
+
+// touch records the stream is being used now.
+func (ms *MuxStream) touch() {
+	atomic.StoreInt64(&ms.used, time.Now().UnixNano())
+}
+
+// IdleTime returns time passed since the stream was used last time.
+func (ms *MuxStream) IdleTime() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&ms.used)))
+}
+
+// Calls returns number of calls currently using the stream.
+func (ms *MuxStream) Calls() int32 {
+	return atomic.LoadInt32(&ms.calls)
+}
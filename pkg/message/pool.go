@@ -1,90 +1,295 @@
 package message
 
 import (
-	"fmt"
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
 	sync "sync"
 	"sync/atomic"
 	"time"
+
+	"go.uber.org/zap"
 )
 
-// MsgStreamPool provides pool of MsgStream to requset and response message.
-// After fetching a MsgStream from the pool, it need to be returned by CloseMsgStream method
-// after finished using it. If there is some problem(connection error, read error..) in the MsgStream,
-// it need to be destroyed by DestroyMsgSteram method so that prevent MsgStreamPool wasting it's max capacity and providing stale stream.
+// ErrNoStream is returned by MsgStreamPool when every stream is draining after GoAway and no connection can be opened
+// until their calls finish. The call can be retried shortly.
+var ErrNoStream = errors.New("no message stream available, connections are draining")
+
+// PoolConfig configures connections opened by MsgStreamPool.
+type PoolConfig struct {
+	MaxConn  int32         // maximum number of connection
+	MaxCalls int32         // number of calls a stream carries before opening another connection
+	MaxIdle  time.Duration // stream not used for this long is closed, it should be shorter than idle timeout of the server
+	Stream   StreamConfig  // timeouts of each stream
+	TLS      *TLSConfig    // connections are made with TLS if it's set
+	Logger   *zap.Logger   // logs connection errors and stale checks, nothing is logged if nil
+}
+
+// MsgStreamPool provides pool of multiplexed message streams to request and response message.
+// A stream fetched from the pool can carry many calls at the same time, the pool hands out the least busy stream
+// and only opens a new connection when every stream already carries maxCalls calls.
+// After fetching a MuxStream from the pool, it need to be returned by closeMsgStream method
+// after finished using it. If there is some problem(connection error, read error..) in the MuxStream,
+// it need to be destroyed by destroyMsgStream method so that prevent MsgStreamPool wasting it's max capacity and providing stale stream.
+// Streams whose server sent GoAway are not handed out anymore, and closed once their calls are finished.
 // MsgStreamPool periodically check whether idle stream is stale or not by sending predefined healthcheck message to it's connection.
 // This periodical stale check is to minmize MsgStreamPool providing stale stream to user.
+// Streams which have not been used for MaxIdle are recycled, so that the pool closes them before the server does.
 type MsgStreamPool struct {
-	pool                         chan *MsgStream //channel where idle connection resides
-	mutex                        sync.Mutex      //mutex for synchronization between getMsgStream and removal of stale connection
-	size                         int32           //total number of connection in pool
-	cap                          int32           //maximum number of connection
-	idle                         int32           //number of idle connection which means not in use and reside in the channel
-	connType, connHost, connPort string          //connection info
+	streams                      []*MuxStream  //streams currently opened
+	slots                        chan struct{} //semaphore limiting number of calls in flight to MaxConn*MaxCalls
+	mutex                        sync.Mutex    //mutex for synchronization between getMsgStream and removal of stale connection
+	dials                        int32         //connections being opened without the mutex, counted against MaxConn
+	dialed                       chan struct{} //closed and replaced whenever a connection being opened is done
+	handshake                    Handshake     //handshake of the stream opened last
+	config                       PoolConfig    //capacity and timeouts of the pool
+	tls                          *tlsLoader    //certificates for TLS connection, nil for plaintext
+	connType, connHost, connPort string        //connection info
 }
 
-// NewMsgStreamPool create new message stream
-func NewMsgStreamPool(connType, connHost, connPort string, cap int32) *MsgStreamPool {
+// NewMsgStreamPool create new message stream pool which opens at most MaxConn connections
+// and pipelines at most MaxCalls calls over each of them.
+func NewMsgStreamPool(connType, connHost, connPort string, config PoolConfig) *MsgStreamPool {
+	if config.MaxCalls < 1 {
+		config.MaxCalls = 1
+	}
+	if config.Logger == nil {
+		config.Logger = zap.NewNop()
+	}
 	pool := &MsgStreamPool{
-		pool:     make(chan *MsgStream, cap),
-		size:     0,
-		cap:      cap,
-		idle:     0,
+		slots:    make(chan struct{}, config.MaxConn*config.MaxCalls),
+		dialed:   make(chan struct{}),
+		config:   config,
 		connType: connType,
 		connHost: connHost,
 		connPort: connPort,
 	}
+	if config.TLS != nil {
+		pool.tls = newTLSLoader(*config.TLS)
+	}
 	go pool.checkStale()
 	return pool
 }
 
 // give back message stream to stream pool
-func (msp *MsgStreamPool) closeMsgStream(stream *MsgStream) {
-	msp.pool <- stream
-	atomic.AddInt32(&msp.idle, 1)
+func (msp *MsgStreamPool) closeMsgStream(stream *MuxStream) {
+	stream.touch()
+	calls := atomic.AddInt32(&stream.calls, -1)
+	// draining stream is not handed out anymore, so it's done once last call finishes
+	if calls == 0 && stream.Draining() {
+		msp.removeMsgStream(stream)
+	}
+	<-msp.slots
 }
 
 // remove message stream from stream pool
-func (msp *MsgStreamPool) destroyMsgStream(stream *MsgStream) {
+func (msp *MsgStreamPool) destroyMsgStream(stream *MuxStream) {
+	msp.removeMsgStream(stream)
+	msp.closeMsgStream(stream)
+}
+
+// close stream and remove it from stream pool, following calls won't get the stream.
+func (msp *MsgStreamPool) removeMsgStream(stream *MuxStream) {
 	stream.Close()
-	atomic.AddInt32(&msp.size, -1)
+	msp.mutex.Lock()
+	defer msp.mutex.Unlock()
+	for i, s := range msp.streams {
+		if s == stream {
+			msp.streams = append(msp.streams[:i], msp.streams[i+1:]...)
+			break
+		}
+	}
 }
 
-// add new message stream to stream pool
-func (msp *MsgStreamPool) pushMsgStream(stream *MsgStream) {
-	msp.pool <- stream
-	atomic.AddInt32(&msp.size, 1)
-	atomic.AddInt32(&msp.idle, 1)
+// expired reports whether stream has not been used longer than MaxIdle and should be recycled.
+func (msp *MsgStreamPool) expired(stream *MuxStream) bool {
+	return msp.config.MaxIdle > 0 && stream.Calls() == 0 && stream.IdleTime() > msp.config.MaxIdle
 }
 
-// Get a msgStream from the pool, if there is idle one, return it.
-// if all stream are being used and there is space for new one, create new one and return.
-// if there is no idel stream and space, it just wait for a stream to be idle
-func (msp *MsgStreamPool) GetMsgStream() (*MsgStream, error) {
-	msp.mutex.Lock()
-	defer msp.mutex.Unlock()
-	// always try to reuse one we already have
-	if msp.idle == 0 && msp.size < msp.cap {
-		conn, err := net.Dial(msp.connType, net.JoinHostPort(msp.connHost, msp.connPort))
-		if err != nil {
-			fmt.Println("Error connecting:", err.Error())
-			return nil, err
+// Get a stream from the pool. The least busy stream is returned if it carries less than MaxCalls calls.
+// New connection starts with handshake, streams whose server doesn't support multiplexing carry one call at a time.
+// if all streams are busy and there is space for new one, create new one and return. Connection is opened without
+// holding the mutex, so that callers of other streams don't wait for it.
+// if every call slot is being used, it wait for a call to finish until ctx is done.
+// ErrNoStream is returned if every stream is draining and the pool already has MaxConn connections.
+func (msp *MsgStreamPool) GetMsgStream(ctx context.Context) (*MuxStream, error) {
+	select {
+	case msp.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	}
+	for {
+		msp.mutex.Lock()
+		stream := msp.leastBusy()
+		// always try to reuse one we already have
+		if stream != nil && stream.Calls() < stream.maxCalls(msp.config.MaxCalls) {
+			msp.mutex.Unlock()
+			return msp.take(stream), nil
+		}
+		if int32(len(msp.streams))+msp.dials < msp.config.MaxConn {
+			// slot of the connection is reserved while it's being opened
+			msp.dials++
+			msp.mutex.Unlock()
+			stream, err := msp.connect(ctx)
+			msp.mutex.Lock()
+			msp.dials--
+			close(msp.dialed)
+			msp.dialed = make(chan struct{})
+			if err != nil {
+				msp.mutex.Unlock()
+				<-msp.slots
+				return nil, err
+			}
+			msp.streams = append(msp.streams, stream)
+			msp.mutex.Unlock()
+			return msp.take(stream), nil
+		}
+		if stream != nil {
+			msp.mutex.Unlock()
+			return msp.take(stream), nil
+		}
+		if msp.dials == 0 {
+			msp.mutex.Unlock()
+			<-msp.slots
+			return nil, ErrNoStream
+		}
+		// every stream is draining, wait for connections being opened by other callers
+		dialed := msp.dialed
+		msp.mutex.Unlock()
+		select {
+		case <-dialed:
+		case <-ctx.Done():
+			<-msp.slots
+			return nil, contextError(ctx)
+		}
+	}
+}
+
+// leastBusy drops broken, expired and finished draining streams, and returns the least busy of the others.
+// nil is returned if there is none. mutex must be held.
+func (msp *MsgStreamPool) leastBusy() *MuxStream {
+	var stream *MuxStream
+	alive := msp.streams[:0]
+	for _, s := range msp.streams {
+		// drop streams broken while nobody was using them, and ones the server may be about to close
+		if s.Err() != nil || msp.expired(s) || (s.Draining() && s.Calls() == 0) {
+			s.Close()
+			continue
+		}
+		alive = append(alive, s)
+		if s.Draining() {
+			continue
+		}
+		if stream == nil || s.Calls() < stream.Calls() {
+			stream = s
+		}
+	}
+	msp.streams = alive
+	return stream
+}
+
+// take counts a call on stream and returns it.
+func (msp *MsgStreamPool) take(stream *MuxStream) *MuxStream {
+	atomic.AddInt32(&stream.calls, 1)
+	stream.touch()
+	return stream
+}
+
+// connect opens new connection and makes handshake on it. It is called without the mutex.
+func (msp *MsgStreamPool) connect(ctx context.Context) (*MuxStream, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, msp.connType, net.JoinHostPort(msp.connHost, msp.connPort))
+	if err != nil {
+		msp.config.Logger.Error("Error connecting", zap.String("error", err.Error()))
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
 		}
-		stream, err := NewMsgStream(conn, 60)
+		return nil, err
+	}
+	if msp.tls != nil {
+		conn, err = msp.tlsClient(ctx, conn)
 		if err != nil {
+			msp.config.Logger.Error("Error in TLS handshake", zap.String("error", err.Error()))
 			return nil, err
 		}
-		msp.pushMsgStream(stream)
 	}
-	stream := <-msp.pool
-	atomic.AddInt32(&msp.idle, -1)
-	return stream, nil
+	// responses are waited by MuxStream as long as the connection is open, so it has no idle timeout
+	streamConfig := msp.config.Stream
+	streamConfig.IdleTimeout = 0
+	msgStream, err := NewMsgStream(conn, streamConfig)
+	if err != nil {
+		return nil, err
+	}
+	hs, err := clientHandshake(ctx, msgStream)
+	if err != nil {
+		msp.config.Logger.Error("Error in handshake", zap.String("error", err.Error()))
+		msgStream.Close()
+		return nil, err
+	}
+	msp.mutex.Lock()
+	msp.handshake = hs
+	msp.mutex.Unlock()
+	return newMuxStream(msgStream, hs, msp.removeDrained), nil
+}
+
+// tlsClient starts TLS on conn with certificates currently in files. TLS handshake is bounded by deadline of ctx.
+// conn is closed if handshake fails.
+func (msp *MsgStreamPool) tlsClient(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	config, err := msp.tls.clientConfig(msp.connHost)
+	if config == nil {
+		conn.Close()
+		return nil, err
+	}
+	if err != nil {
+		msp.config.Logger.Warn("Error reloading TLS certificates, using previous ones", zap.String("error", err.Error()))
+	}
+	tlsConn := tls.Client(conn, config)
+	d, _ := ctx.Deadline()
+	tlsConn.SetDeadline(d)
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, contextError(ctx)
+		}
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// Handshake returns version and features negotiated with the server on the connection opened last.
+// ok is false if no connection has been opened yet.
+func (msp *MsgStreamPool) Handshake() (hs Handshake, ok bool) {
+	msp.mutex.Lock()
+	defer msp.mutex.Unlock()
+	return msp.handshake, msp.handshake.Version != 0
+}
+
+// removeDrained closes streams the server sent GoAway to, once their calls are finished.
+func (msp *MsgStreamPool) removeDrained() {
+	msp.mutex.Lock()
+	defer msp.mutex.Unlock()
+	alive := msp.streams[:0]
+	for _, s := range msp.streams {
+		if s.Draining() && s.Calls() == 0 {
+			s.Close()
+			continue
+		}
+		alive = append(alive, s)
+	}
+	msp.streams = alive
 }
 
 // periodically remove stale connections from pool.
 func (msp *MsgStreamPool) checkStale() {
-	// check every stale connections every 20 seconds
-	ticker := time.NewTicker(time.Second * 20)
+	// check every stale connections every 20 seconds, or more often to recycle idle streams in time
+	interval := time.Second * 20
+	if msp.config.MaxIdle > 0 && msp.config.MaxIdle/2 < interval {
+		interval = msp.config.MaxIdle / 2
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
@@ -94,40 +299,47 @@ func (msp *MsgStreamPool) checkStale() {
 	}
 }
 
-//remove stale stream from pool, only check idle streams.
+// remove stale stream from pool, only check idle streams. Streams idle for longer than MaxIdle are recycled.
 func (msp *MsgStreamPool) removeStaleStreams() {
 	msp.mutex.Lock()
-	idleCnt := msp.idle
-	streams := make([]*MsgStream, idleCnt)
-	//take all idle stream
-	for i := int32(0); i < idleCnt; i++ {
-		streams[i] = <-msp.pool
-		atomic.AddInt32(&msp.idle, -1)
+	streams := make([]*MuxStream, 0, len(msp.streams))
+	var expired []*MuxStream
+	alive := msp.streams[:0]
+	for _, s := range msp.streams {
+		// streams are taken under the lock, so expired stream can't be handed out while being removed
+		if msp.expired(s) {
+			expired = append(expired, s)
+			continue
+		}
+		alive = append(alive, s)
+		if s.Calls() == 0 {
+			streams = append(streams, s)
+		}
 	}
+	msp.streams = alive
+	total := len(msp.streams)
 	msp.mutex.Unlock()
+	for _, stream := range expired {
+		stream.Close()
+	}
 	removed := 0
-	fmt.Println("idle connections : ", msp.idle, msp.size)
 	for _, stream := range streams {
-		//check if stale, remove stale connection, put back others
+		//check if stale, remove stale connection
 		if isStaleStream(stream) {
-			msp.destroyMsgStream(stream)
+			msp.removeMsgStream(stream)
 			removed++
-		} else {
-			msp.closeMsgStream(stream)
 		}
 	}
-	fmt.Printf("removed %d stale streams.\n", removed)
+	msp.config.Logger.Debug("Checked idle streams", zap.Int("idle", len(streams)), zap.Int("total", total), zap.Int("stale", removed), zap.Int("recycled", len(expired)))
 }
 
+// healthCheckTimeout is the time a stream has to answer healthcheck message before considered stale.
+const healthCheckTimeout = 5 * time.Second
+
 // send predefined healtcheck msg to check health of connection
-func isStaleStream(s *MsgStream) bool {
-	err := s.WriteMsg(&HealthcheckMessage{})
-	if err != nil {
-		return true
-	}
-	_, err = s.ReadMsg()
-	if err != nil {
-		return true
-	}
-	return false
+func isStaleStream(s *MuxStream) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	_, err := s.Call(ctx, &HealthcheckMessage{})
+	return err != nil
 }
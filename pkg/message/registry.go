@@ -0,0 +1,116 @@
+package message
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Registry maps message type numbers to message types and back.
+// Type number written in front of every message will later be used by message reader to determine how to translate it.
+// Numbers are declared in .proto files with (msg_num) message option, so adding new message only needs
+// a new message with unused number in .proto file and regenerating go code, which registers the file.
+type Registry struct {
+	nums  map[protoreflect.FullName]uint    // message name to message type number
+	types map[uint]protoreflect.MessageType // message type number to message type
+}
+
+// NewRegistry builds registry from messages declared in given proto files.
+// Messages without (msg_num) option are not registered, they can only be used as a field of other message.
+// Returns error if two messages have same number or message type is not linked to the binary.
+func NewRegistry(files ...protoreflect.FileDescriptor) (*Registry, error) {
+	r := newRegistry()
+	for _, file := range files {
+		if err := r.Register(file); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func newRegistry() *Registry {
+	return &Registry{
+		nums:  make(map[protoreflect.FullName]uint),
+		types: make(map[uint]protoreflect.MessageType),
+	}
+}
+
+// Register adds messages declared in file to the registry, see NewRegistry. Nothing is added on error.
+// It must not be called while messages are looked up.
+func (r *Registry) Register(file protoreflect.FileDescriptor) error {
+	nums := make(map[protoreflect.FullName]uint)
+	types := make(map[uint]protoreflect.MessageType)
+	msgs := file.Messages()
+	for i := 0; i < msgs.Len(); i++ {
+		md := msgs.Get(i)
+		opts := md.Options()
+		if opts == nil || !proto.HasExtension(opts, E_MsgNum) {
+			continue
+		}
+		num := uint(proto.GetExtension(opts, E_MsgNum).(uint32))
+		dup, ok := r.types[num]
+		if !ok {
+			dup, ok = types[num]
+		}
+		if ok {
+			return fmt.Errorf("message %s and %s have same type number %d", dup.Descriptor().FullName(), md.FullName(), num)
+		}
+		mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+		if err != nil {
+			return fmt.Errorf("cannot find go type of message %s: %v", md.FullName(), err)
+		}
+		nums[md.FullName()] = num
+		types[num] = mt
+	}
+	for name, num := range nums {
+		r.nums[name] = num
+		r.types[num] = types[num]
+	}
+	return nil
+}
+
+// MsgNum return message type number of message.
+func (r *Registry) MsgNum(msg proto.Message) (uint, error) {
+	name := msg.ProtoReflect().Descriptor().FullName()
+	num, ok := r.nums[name]
+	if !ok {
+		return 0, fmt.Errorf("message %s has no type number", name)
+	}
+	return num, nil
+}
+
+// NewMsg create empty message container of given message type number.
+func (r *Registry) NewMsg(num uint) (proto.Message, error) {
+	mt, ok := r.types[num]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type number %d", num)
+	}
+	return mt.New().Interface(), nil
+}
+
+// registry contains every message of the protocol, registered by generated code of each .proto file when the
+// package is loaded, so misconfigured message numbers stop the program at startup.
+var registry = newRegistry()
+
+// RegisterFile adds messages of file to the protocol. It is called by init of code generated by protoc-gen-gomsg,
+// and panics if file is not built yet or it's message numbers conflict.
+func RegisterFile(file protoreflect.FileDescriptor) {
+	if file == nil {
+		panic("message: file descriptor is registered before it is built")
+	}
+	if err := registry.Register(file); err != nil {
+		panic("message: invalid message registry: " + err.Error())
+	}
+}
+
+// getMsgNum return message type number of message
+func getMsgNum(msg proto.Message) (uint, error) {
+	return registry.MsgNum(msg)
+}
+
+// getMsgContainer find corresponding container by message type number and return it.
+func getMsgContainer(typeNum uint) (proto.Message, error) {
+	return registry.NewMsg(typeNum)
+}
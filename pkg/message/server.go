@@ -1,65 +1,202 @@
 package message
 
 import (
-	"database/sql"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"git.garena.com/youngiek.song/entry_task/internal/jwt"
 	"git.garena.com/youngiek.song/entry_task/internal/models"
+	"git.garena.com/youngiek.song/entry_task/internal/notifier"
+	"git.garena.com/youngiek.song/entry_task/internal/session"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
+// ServiceDesc describes a service and it's methods. It is generated by protoc-gen-gomsg
+// from service definition in .proto files and registered to Server by generated RegisterXXXServer functions.
+type ServiceDesc struct {
+	ServiceName string      // full name of the service
+	HandlerType interface{} // pointer to server interface of the service, to check implementation on registration
+	Methods     []MethodDesc
+}
+
+// MethodDesc describes a method of a service.
+type MethodDesc struct {
+	MethodName string        // name of the method
+	Request    proto.Message // empty request message, server dispatch requests to method by it's message type
+	Response   proto.Message // empty response message, used to build response for error
+	Handler    func(srv interface{}, ctx context.Context, req proto.Message) (proto.Message, error)
+}
+
+// handler is a method registered to server with implementation of it's service.
+type handler struct {
+	method *MethodDesc
+	srv    interface{}
+	info   *UnaryServerInfo // passed to interceptors
+}
+
+// maxConcurrentRequests is the maximum number of requests handled concurrently on a single connection.
+// Reading further requests from the connection waits until one of them is finished.
+const maxConcurrentRequests = 64
+
+// ServerConfig configures connections accepted by Server and it's user service.
+type ServerConfig struct {
+	Stream    StreamConfig    // timeouts of client connections
+	TLS       *TLSConfig      // connections are accepted with TLS if it's set, setting CAFile requires client certificate
+	RateLimit RateLimitConfig // requests of each client to each method, checked before authentication
+	OnPanic   func(string)    // called with method of every panic recovered from a handler, to count them
+
+	ResetTokenExpire   time.Duration         // lifetime of password reset tokens, 15 minutes if 0
+	RefreshTokenExpire time.Duration         // lifetime of refresh tokens, 7 days if 0
+	Notifier           notifier.Notifier     // delivers password reset tokens, written to stdout if nil
+	Sessions           session.Manager       // issues access tokens, JWT of tokenIssuer of NewServer if nil
+	ResetTokens        session.ResetTokens   // password reset tokens, in memory of this server if nil
+	RefreshTokens      session.RefreshTokens // refresh tokens of logins, in memory of this server if nil
+}
+
+// RateLimitConfig limits requests with RateLimitInterceptor, requests are not limited if Rate is 0.
+type RateLimitConfig struct {
+	Rate  float64 // requests per second
+	Burst int     // requests allowed at once
+}
+
 // Server listens request from message.client.
 type Server struct {
-	listener    net.Listener                             // listener to accept new connection
-	handlers    map[uint]func(*MsgStream, proto.Message) // pre-registered handlers for each request
-	db          *sql.DB                                  // database connection to user DB
-	tokenIssuer *jwt.TokenIssuer                         // Generate and Authenticate JWT Token with secret Key
-	logger      *zap.Logger                              // for log
-	host, port  string                                   // listen host and port
+	listener     net.Listener             // listener to accept new connection
+	handlers     map[uint]*handler        // pre-registered handlers for each request
+	interceptors []UnaryServerInterceptor // run around every handler, first one is the outermost
+	streamConfig StreamConfig             // timeouts of client connections
+	logger       *zap.Logger              // for log
+	host, port   string                   // listen host and port
+
+	mutex    sync.Mutex          // protects streams and draining
+	streams  map[*MsgStream]bool // open connections, true once handshake is done
+	draining bool                // set by Shutdown, no more connection is accepted
+	conns    sync.WaitGroup      // number of open connections
 }
 
-// NewServer create new instance of server.
-func NewServer(host, port string, db *sql.DB, tokenIssuer *jwt.TokenIssuer, logger *zap.Logger) *Server {
+// NewServer create new instance of server. Connection of client is closed when it has been idle
+// for config.Stream.IdleTimeout without any request in progress.
+func NewServer(host, port string, config ServerConfig, store models.UserStore, tokenIssuer *jwt.TokenIssuer, logger *zap.Logger) *Server {
 	// initialize listen socket
 	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
 	if err != nil {
 		logger.Fatal("Error opening listen socket")
 		os.Exit(1)
 	}
+	if config.TLS != nil {
+		listener = tlsListener(listener, *config.TLS, logger)
+	}
 
 	server := &Server{
-		host:        host,
-		port:        port,
-		listener:    listener,
-		handlers:    make(map[uint]func(*MsgStream, proto.Message)),
-		db:          db,
-		tokenIssuer: tokenIssuer,
-		logger:      logger,
+		host:         host,
+		port:         port,
+		listener:     listener,
+		handlers:     make(map[uint]*handler),
+		streams:      make(map[*MsgStream]bool),
+		streamConfig: config.Stream,
+		logger:       logger,
+	}
+	if config.ResetTokenExpire <= 0 {
+		config.ResetTokenExpire = 15 * time.Minute
+	}
+	if config.RefreshTokenExpire <= 0 {
+		config.RefreshTokenExpire = 7 * 24 * time.Hour
+	}
+	if config.Notifier == nil {
+		config.Notifier = notifier.NewFileNotifier("")
+	}
+	if config.ResetTokens == nil {
+		config.ResetTokens = session.NewMemoryResetTokens(config.ResetTokenExpire)
 	}
+	if config.RefreshTokens == nil {
+		config.RefreshTokens = session.NewMemoryRefreshTokens(config.RefreshTokenExpire, session.RefreshGrace)
+	}
+	if config.Sessions == nil {
+		config.Sessions = session.NewJWTManager(tokenIssuer, session.NewMemoryRecords(config.RefreshTokenExpire))
+	}
+	server.Use(LoggingInterceptor(logger), RecoveryInterceptor(logger, config.OnPanic))
+	if config.RateLimit.Rate > 0 {
+		server.Use(RateLimitInterceptor(config.RateLimit.Rate, config.RateLimit.Burst))
+	}
+	// user service relies on tokens authenticated by AuthInterceptor
+	server.Use(AuthInterceptor(config.Sessions, logger))
 	// register handler for each message
-	server.registerHandler(&HealthcheckMessage{}, server.healthCheck)
-	server.registerHandler(&LoginRequest{}, server.login)
-	server.registerHandler(&GetUserInfoRequest{}, server.getUserInfo)
-	server.registerHandler(&EditUserInfoRequest{}, server.editUserInfo)
-	server.registerHandler(&AuthRequest{}, server.authenticate)
+	RegisterHealthServer(server, healthServer{})
+	RegisterUserServiceServer(server, &userServer{
+		store:         store,
+		sessions:      config.Sessions,
+		tokenIssuer:   tokenIssuer,
+		resetTokens:   config.ResetTokens,
+		refreshTokens: config.RefreshTokens,
+		notifier:      config.Notifier,
+		logger:        logger,
+	})
 	return server
 }
 
+// tlsListener wraps listener to accept TLS connections. Certificate files are checked for modification
+// on every new connection, so renewed certificates are used without restarting the server.
+func tlsListener(listener net.Listener, config TLSConfig, logger *zap.Logger) net.Listener {
+	loader := newTLSLoader(config)
+	_, err := loader.serverConfig()
+	if err != nil {
+		logger.Fatal("Error loading TLS certificates", zap.String("error", err.Error()))
+	}
+	return tls.NewListener(listener, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := loader.serverConfig()
+			if err != nil {
+				logger.Error("Error reloading TLS certificates, using previous ones", zap.String("error", err.Error()))
+			}
+			return c, nil
+		},
+	})
+}
+
+// RegisterService registers every method of service implemented by srv.
+// It is called by generated RegisterXXXServer functions, srv must implement server interface of the service.
+func (server *Server) RegisterService(sd *ServiceDesc, srv interface{}) {
+	ht := reflect.TypeOf(sd.HandlerType).Elem()
+	if !reflect.TypeOf(srv).Implements(ht) {
+		server.logger.Fatal("Service implementation does not satisfy interface", zap.String("service", sd.ServiceName), zap.String("interface", ht.String()))
+	}
+	for i := range sd.Methods {
+		info := &UnaryServerInfo{Server: srv, FullMethod: "/" + sd.ServiceName + "/" + sd.Methods[i].MethodName}
+		err := server.registerHandler(sd.Methods[i].Request, &handler{method: &sd.Methods[i], srv: srv, info: info})
+		if err != nil {
+			server.logger.Fatal("Error registering method", zap.String("service", sd.ServiceName), zap.String("method", sd.Methods[i].MethodName), zap.String("error", err.Error()))
+		}
+	}
+}
+
+// Use adds interceptors run around every handler of the server, after the ones added before.
+// NewServer adds logging, panic recovery and token authentication, so interceptors added by Use run inside them.
+// Use must be called before Run.
+func (server *Server) Use(interceptors ...UnaryServerInterceptor) {
+	server.interceptors = append(server.interceptors, interceptors...)
+}
+
 // registerHandler function to server's handler
-func (server *Server) registerHandler(msg proto.Message, handler func(*MsgStream, proto.Message)) error {
+func (server *Server) registerHandler(msg proto.Message, h *handler) error {
 	msgNum, err := getMsgNum(msg)
 	if err != nil {
 		return err
 	}
-	server.handlers[msgNum] = handler
+	server.handlers[msgNum] = h
 	return nil
 }
 
 // getHandler map message to it's corresponding handler function.
-func (server *Server) getHandler(msg proto.Message) (func(*MsgStream, proto.Message), error) {
+func (server *Server) getHandler(msg proto.Message) (*handler, error) {
 	msgNum, err := getMsgNum(msg)
 	if err != nil {
 		return nil, err
@@ -73,165 +210,245 @@ func (server *Server) Run() {
 	for {
 		conn, err := server.listener.Accept()
 		if err != nil {
-			server.logger.Error("Error accepting connection", zap.String("error", err.Error()))
+			if server.isDraining() {
+				server.logger.Info("Backend Server stopped accepting connections")
+			} else {
+				server.logger.Error("Error accepting connection", zap.String("error", err.Error()))
+			}
 			break
 		}
 		go server.handleRequest(conn)
 	}
 }
 
-// handleRequest process request and send response to client.
+// Addr returns address the server listens on, with the port chosen by the system if it was "0".
+func (server *Server) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+// handleRequest process requests and send responses to client.
+// Each request is handled in it's own goroutine, so responses are written as soon as they are ready
+// and may be sent in different order from requests. Client matches them by request id.
+// Connection starts with handshake, client with incompatible protocol version is rejected with ProtocolError.
+// Connection is closed when no request arrives within idle timeout while none is in progress.
+// While shutting down, requests keep being handled until the client closes the connection after GoAway.
 func (server *Server) handleRequest(conn net.Conn) {
-	stream, _ := NewMsgStream(conn, 60)
+	stream, _ := NewMsgStream(conn, server.streamConfig)
 	defer server.logger.Info("close connection", zap.String("remote", stream.RemoteAddr()))
 	defer stream.Close()
+	if !server.addStream(stream) {
+		return
+	}
+	defer server.removeStream(stream)
+	var wg sync.WaitGroup
+	// wait for running handlers before closing connection so that their responses are not lost
+	defer wg.Wait()
+	hs, err := serverHandshake(stream)
+	if err != nil {
+		server.logger.Error("Error in handshake", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
+		server.rejectFrame(stream, err)
+		return
+	}
+	server.streamReady(stream)
+	server.logger.Debug("handshake", zap.String("remote", stream.RemoteAddr()), zap.Uint32("version", hs.Version),
+		zap.Strings("features", hs.Features), zap.String("build", hs.PeerBuild))
+	ctx := context.WithValue(context.Background(), streamKey{}, stream)
+	// client not supporting multiplexing expects responses in order of requests
+	concurrency := maxConcurrentRequests
+	if !hs.HasFeature(FeatureMultiplexing) {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var inFlight int32 // number of requests being handled
 	for {
 		//wait for next request
-		msg, err := stream.ReadMsg()
+		reqID, msg, err := stream.ReadMsg()
 		if err != nil {
-			if terr, ok := err.(net.Error); ok && terr.Timeout() {
+			if err == ErrIdleTimeout {
+				// connection is not idle while it's requests are in progress
+				if atomic.LoadInt32(&inFlight) > 0 {
+					continue
+				}
 				server.logger.Info("Connection timeout waiting for new request", zap.String("remote", stream.RemoteAddr()))
 			} else {
 				server.logger.Error("Error receiving request", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
+				server.rejectFrame(stream, err)
 			}
 			break
 		}
-		handler, err := server.getHandler(msg)
-		if err != nil {
-			server.logger.Error("Not handler registered message", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
+		h, err := server.getHandler(msg)
+		if err != nil || h == nil {
+			server.logger.Error("Not handler registered message", zap.String("remote", stream.RemoteAddr()), zap.Any("error", err))
+			server.rejectFrame(stream, fmt.Errorf("%w: no handler for %s", ErrUnknownMessageType, msg.ProtoReflect().Descriptor().FullName()))
 			break
 		}
-		handler(stream, msg)
+		sem <- struct{}{}
+		wg.Add(1)
+		atomic.AddInt32(&inFlight, 1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer atomic.AddInt32(&inFlight, -1)
+			err := stream.WriteMsg(reqID, server.handle(ctx, h, msg))
+			if err != nil {
+				server.logger.Error("Error sending response", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
+			}
+		}()
 	}
 }
 
-// healthCheck handles healthCheck message from client. It is just for check health of server.
-func (server *Server) healthCheck(stream *MsgStream, r proto.Message) {
-	stream.WriteMsg(&HealthcheckMessage{})
+// addStream registers new connection, false is returned if the server is shutting down.
+func (server *Server) addStream(stream *MsgStream) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if server.draining {
+		return false
+	}
+	server.streams[stream] = false
+	server.conns.Add(1)
+	return true
 }
 
-// login handles login request. Compare password of user with db's data.
-// On success, response with generated jwt token and error code 0.
-// On fail, response with empty token and positive error code.
-func (server *Server) login(stream *MsgStream, r proto.Message) {
-	req := r.(*LoginRequest)
-	id := req.Id
-	password := req.Password
-	valid, err := models.Authenticate(server.db, id, password)
-	if err != nil {
-		server.logger.Error("Error authenticating id/password", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&LoginResponse{
-			Response: &Response{Code: 2},
-		})
-		return
+// streamReady marks handshake of the connection done. If the server is shutting down, GoAway is sent right away.
+func (server *Server) streamReady(stream *MsgStream) {
+	server.mutex.Lock()
+	server.streams[stream] = true
+	draining := server.draining
+	server.mutex.Unlock()
+	if draining {
+		server.goAway(stream)
 	}
-	if !valid {
-		server.logger.Warn("invalid Id/password", zap.String("remote", stream.RemoteAddr()), zap.String("id", id), zap.String("password", password))
-		stream.WriteMsg(&LoginResponse{
-			Response: &Response{Code: 1},
-		})
-		return
-	}
-	msg := &LoginResponse{
-		Response: &Response{Code: 0},
-		Token:    server.tokenIssuer.GenerateToken(id),
-	}
-	stream.WriteMsg(msg)
-	server.logger.Info("Handled login request", zap.String("remote", stream.RemoteAddr()), zap.String("id", id))
 }
 
-// getUserInfo check client's priviliege by JWT token and get user's information from DB.
-// On success, response with user data and error code 0.
-// On fail, response with user data and positive error code.
-func (server *Server) getUserInfo(stream *MsgStream, r proto.Message) {
-	req := r.(*GetUserInfoRequest)
-	id, err := server.tokenIssuer.AuthenticateToken(req.Token)
+// removeStream unregisters closed connection.
+func (server *Server) removeStream(stream *MsgStream) {
+	server.mutex.Lock()
+	delete(server.streams, stream)
+	server.mutex.Unlock()
+	server.conns.Done()
+}
+
+func (server *Server) isDraining() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.draining
+}
+
+// goAway tells the client to stop sending requests on the connection.
+func (server *Server) goAway(stream *MsgStream) {
+	err := stream.WriteMsg(0, &GoAway{Reason: "server shutting down"})
 	if err != nil {
-		server.logger.Error("Token authentication failed", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&GetUserInfoResponse{
-			Response: &Response{Code: 3},
-		})
-		return
+		server.logger.Error("Error sending goaway", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
 	}
-	if id == "" {
-		server.logger.Warn("Invalid token", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&GetUserInfoResponse{
-			Response: &Response{Code: 1},
-		})
-		return
+}
+
+// Shutdown stops accepting connections and sends GoAway to every connected client. Requests in progress
+// and requests sent before the client got GoAway are still handled, and clients close their connections once
+// their requests are answered. Shutdown returns when every connection is closed, or when ctx is done,
+// in which case remaining connections are closed forcibly and ctx's error is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mutex.Lock()
+	server.draining = true
+	var ready, pending []*MsgStream
+	for stream, handshaked := range server.streams {
+		if handshaked {
+			ready = append(ready, stream)
+		} else {
+			pending = append(pending, stream)
+		}
 	}
-	user, err := models.GetUserById(server.db, id)
-	if err != nil {
-		server.logger.Error("Error on DB", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&GetUserInfoResponse{
-			Response: &Response{Code: 2},
-		})
-		return
+	server.mutex.Unlock()
+	server.listener.Close()
+	// connection still in handshake hasn't sent any request yet
+	for _, stream := range pending {
+		stream.Close()
 	}
-	if user == nil {
-		server.logger.Info("No such user", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&GetUserInfoResponse{
-			Response: &Response{Code: 3},
-		})
-		return
+	for _, stream := range ready {
+		go server.goAway(stream)
 	}
-	stream.WriteMsg(&GetUserInfoResponse{
-		Response: &Response{Code: 0},
-		User: &User{
-			Id:       user.Id,
-			Nickname: user.Nickname,
-			PicPath:  user.PicPath,
-		},
-	})
-	server.logger.Info("Handled GetUserInfo request", zap.String("remote", stream.RemoteAddr()), zap.String("id", id))
-}
 
-// editUserInfo check client's priviliege by JWT token and edit user's information from DB.
-// On success, response with error code 0.
-// On fail, response with positive error code.
-func (server *Server) editUserInfo(stream *MsgStream, r proto.Message) {
-	req := r.(*EditUserInfoRequest)
-	id, err := server.tokenIssuer.AuthenticateToken(req.Token)
-	if err != nil {
-		server.logger.Error("Token authentication failed", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&Response{Code: 3})
-		return
+	done := make(chan struct{})
+	go func() {
+		server.conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		server.logger.Info("Backend Server has shut down")
+		return nil
+	case <-ctx.Done():
+		server.mutex.Lock()
+		for stream := range server.streams {
+			stream.Close()
+		}
+		server.mutex.Unlock()
+		server.logger.Warn("Backend Server shutdown deadline passed, connections closed", zap.String("error", ctx.Err().Error()))
+		return ctx.Err()
 	}
-	if id == "" {
-		server.logger.Warn("Invalid token", zap.String("remote", stream.RemoteAddr()), zap.String("token", req.Token))
-		stream.WriteMsg(&Response{Code: 1})
+}
+
+// rejectFrame tells the client why it's connection is about to be closed, if err is a protocol error.
+// Requests already in progress are still answered before the connection is closed.
+func (server *Server) rejectFrame(stream *MsgStream, err error) {
+	msg, ok := newProtocolErrorMsg(err)
+	if !ok {
 		return
 	}
-	err = models.SetUser(server.db, &models.User{
-		Id:       req.User.Id,
-		Nickname: req.User.Nickname,
-		PicPath:  req.User.PicPath,
-	})
+	err = stream.WriteMsg(0, msg)
 	if err != nil {
-		server.logger.Error("Error on DB", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&Response{Code: 2})
-		return
+		server.logger.Error("Error sending protocol error", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
 	}
-	stream.WriteMsg(&Response{Code: 0})
-	server.logger.Info("Handled EditUserInfo request", zap.String("remote", stream.RemoteAddr()), zap.String("id", id), zap.String("body", req.User.String()))
 }
 
-// getUserInfo check client's priviliege by JWT token.
-// On success, response with error code 0.
-// On fail, response with positive error code.
-func (server *Server) authenticate(stream *MsgStream, r proto.Message) {
-	req := r.(*AuthRequest)
-	id, err := server.tokenIssuer.AuthenticateToken(req.Token)
+// handle calls method implementation through interceptors and returns response to be sent to the client.
+// If the method fails, response with error code of the error is returned.
+func (server *Server) handle(ctx context.Context, h *handler, req proto.Message) proto.Message {
+	invoke := func(ctx context.Context, req proto.Message) (proto.Message, error) {
+		return h.method.Handler(h.srv, ctx, req)
+	}
+	res, err := ChainUnaryInterceptors(server.interceptors...)(ctx, req, h.info, invoke)
 	if err != nil {
-		server.logger.Error("Token authentication failed", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&Response{Code: 1})
-		return
+		return errorResponse(h.method.Response, err)
 	}
-	if id == "" {
-		server.logger.Info("Invalid token", zap.String("remote", stream.RemoteAddr()), zap.String("error", err.Error()))
-		stream.WriteMsg(&Response{Code: 1})
-		return
+	return res
+}
+
+// errorResponse create new response message of same type as res, having error code and message of err.
+// Response is filled for message Response itself or messages having Response field named response.
+func errorResponse(res proto.Message, err error) proto.Message {
+	code := responseFromError(err)
+	if _, ok := res.(*Response); ok {
+		return code
+	}
+	out := res.ProtoReflect().New()
+	fd := out.Descriptor().Fields().ByName("response")
+	if fd != nil && fd.Message() != nil && fd.Message().FullName() == code.ProtoReflect().Descriptor().FullName() {
+		out.Set(fd, protoreflect.ValueOfMessage(code.ProtoReflect()))
 	}
-	stream.WriteMsg(&Response{Code: 0})
-	server.logger.Info("Handled Authenticate request", zap.String("remote", stream.RemoteAddr()), zap.String("id", id))
+	return out.Interface()
+}
+
+// streamKey is context key of the stream a request was received from.
+type streamKey struct{}
+
+// StreamFromContext returns the stream request being handled was received from.
+func StreamFromContext(ctx context.Context) *MsgStream {
+	stream, _ := ctx.Value(streamKey{}).(*MsgStream)
+	return stream
+}
+
+// remoteAddr returns remote address of the client request in ctx was received from.
+func remoteAddr(ctx context.Context) string {
+	stream := StreamFromContext(ctx)
+	if stream == nil {
+		return ""
+	}
+	return stream.RemoteAddr()
+}
+
+// healthServer handles healthCheck message from client. It is just for check health of server.
+type healthServer struct{}
+
+func (healthServer) Check(ctx context.Context, req *HealthcheckMessage) (*HealthcheckMessage, error) {
+	return &HealthcheckMessage{}, nil
 }
@@ -2,8 +2,14 @@ package message
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net"
+	sync "sync"
 	"time"
 
 	"google.golang.org/protobuf/proto"
@@ -11,19 +17,69 @@ import (
 
 // MsgStream represent stream of messages. Message is abstraction of a request from client or a response from server.
 // Every message have same format like below.
-// Message format : | Message Type(varint) | Protobuf data(len-delim data) |
+// Message format : | Message Type(varint) | Request ID(varint) | Protobuf data(len-delim data) |
+// Request ID correlates a response with the request it answers, so that many requests can be in flight
+// over one stream and their responses can be written in any order. Request ID 0 is reserved for
+// messages which do not belong to any request.
 type MsgStream struct {
-	conn net.Conn      // network connection for message
-	in   *bufio.Reader // read incoming message using this Reader
-	out  *bufio.Writer // write outgoing message using this Writer
-	tmp  []byte        // temporal buffer for varint write
+	conn   net.Conn      // network connection for message
+	in     *bufio.Reader // read incoming message using this Reader
+	out    *bufio.Writer // write outgoing message using this Writer
+	wmutex sync.Mutex    // serialize writes so that frames of concurrent writers never interleave
+	config StreamConfig  // timeouts of read and write
 }
 
-// NewMsgStream create new instance of MsgStream with network connection and read timeout duration.
-func NewMsgStream(conn net.Conn, timeout time.Duration) (*MsgStream, error) {
-	// set maxium read deadline for connection
-	conn.SetReadDeadline(time.Now().Add(time.Second * timeout))
-	return &MsgStream{conn, bufio.NewReader(conn), bufio.NewWriter(conn), make([]byte, 32)}, nil
+// StreamConfig configures timeouts of MsgStream. Timeouts are applied to every message read or written
+// rather than to the connection lifetime, zero timeout means no timeout.
+type StreamConfig struct {
+	IdleTimeout  time.Duration // maximum time to wait for next message to start arriving
+	ReadTimeout  time.Duration // maximum time to read rest of a message once it started arriving
+	WriteTimeout time.Duration // maximum time to write a message
+	MaxFrameSize int           // maximum size of protobuf data in a message, DefaultMaxFrameSize if zero
+}
+
+// DefaultMaxFrameSize is the maximum size of protobuf data in a message when StreamConfig.MaxFrameSize is not set.
+const DefaultMaxFrameSize = 4 << 20
+
+// Protocol errors returned by ReadMsg when the peer sends a frame which cannot be decoded.
+// Stream should not be used after reading one of them, except ErrUnknownMessageType and ErrMalformedMessage
+// whose frame is fully consumed.
+var (
+	ErrFrameTooLarge      = errors.New("message frame too large")
+	ErrUnknownMessageType = errors.New("unknown message type")
+	ErrTruncated          = errors.New("message frame truncated")
+	ErrMalformedMessage   = errors.New("malformed message")
+)
+
+// ErrIdleTimeout is returned by ReadMsg when no message started arriving within IdleTimeout.
+// Stream is still usable after ErrIdleTimeout, so reader may decide to keep waiting.
+var ErrIdleTimeout = errors.New("message stream idle timeout")
+
+// aLongTimeAgo is a deadline in the past, setting it interrupts blocked read or write immediately.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// NewMsgStream create new instance of MsgStream with network connection and timeouts.
+func NewMsgStream(conn net.Conn, config StreamConfig) (*MsgStream, error) {
+	if config.MaxFrameSize <= 0 {
+		config.MaxFrameSize = DefaultMaxFrameSize
+	}
+	return &MsgStream{conn: conn, in: bufio.NewReader(conn), out: bufio.NewWriter(conn), config: config}, nil
+}
+
+// truncated converts EOF in the middle of a message to ErrTruncated.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+// deadline returns deadline of an operation starting now with timeout, zero time if there is no timeout.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
 }
 
 // Close closes stream's undelying network connection.
@@ -32,91 +88,148 @@ func (ms *MsgStream) Close() {
 }
 
 // readVarInt read a variable length integer from undelying network cconnection.
-func (ms *MsgStream) readVarInt() (uint, error) {
+func (ms *MsgStream) readVarInt() (uint64, error) {
 	val, err := binary.ReadUvarint(ms.in)
-	return uint(val), err
-}
-
-// writeVarInt write a variable length integer to undelying network cconnection.
-func (ms *MsgStream) writeVarInt(val uint) error {
-	n := binary.PutUvarint(ms.tmp, uint64(val))
-	n, err := ms.out.Write(ms.tmp[:n])
-	return err
+	return val, truncated(err)
 }
 
-// readLenDelimData read length-delimted data from stream
+// readLenDelimData read length-delimted data from stream.
+// Length is checked against MaxFrameSize before allocating, so a malformed length can't exhaust memory.
 func (ms *MsgStream) readLenDelimData() ([]byte, error) {
-	size, err := binary.ReadUvarint(ms.in)
+	size, err := ms.readVarInt()
 	if err != nil {
 		return nil, err
 	}
+	if size > uint64(ms.config.MaxFrameSize) {
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d bytes", ErrFrameTooLarge, size, ms.config.MaxFrameSize)
+	}
 	data := make([]byte, size)
-	ptr := uint64(0)
-	var n int
-	for ptr < size {
-		n, err = ms.in.Read(data[ptr:len(data)])
-		if err != nil {
-			return nil, err
-		}
-		ptr += uint64(n)
+	_, err = io.ReadFull(ms.in, data)
+	if err != nil {
+		return nil, truncated(err)
 	}
 	return data, nil
 }
 
-// writeLenDelimData write length-delimted data to stream
-func (ms *MsgStream) writeLenDelimData(data []byte) error {
-	n := binary.PutUvarint(ms.tmp, uint64(len(data)))
-	n, err := ms.out.Write(ms.tmp[:n])
+// ReadMsg read a message from stream and return it with the request id it belongs to.
+// Message consist of message type(varint) + request id(varint) + data(protobuf data)
+// Frames which cannot be decoded are reported with ErrFrameTooLarge, ErrUnknownMessageType, ErrTruncated or ErrMalformedMessage.
+// ReadMsg must not be called concurrently, there should be only one reader per stream.
+func (ms *MsgStream) ReadMsg() (uint64, proto.Message, error) {
+	// wait for first byte of next message with idle timeout, then read the rest with read timeout
+	ms.conn.SetReadDeadline(deadline(ms.config.IdleTimeout))
+	_, err := ms.in.Peek(1)
 	if err != nil {
-		return err
+		if terr, ok := err.(net.Error); ok && terr.Timeout() {
+			return 0, nil, ErrIdleTimeout
+		}
+		return 0, nil, err
 	}
-	n, err = ms.out.Write(data)
-	return err
-}
-
-// ReadMsg read a message from stream. Message consist of message type(varint) + data(protobuf data)
-func (ms *MsgStream) ReadMsg() (proto.Message, error) {
+	ms.conn.SetReadDeadline(deadline(ms.config.ReadTimeout))
 	typeNum, err := ms.readVarInt()
 	if err != nil {
-		return nil, err
+		return 0, nil, err
+	}
+	reqID, err := ms.readVarInt()
+	if err != nil {
+		return 0, nil, err
 	}
 	data, err := ms.readLenDelimData()
 	if err != nil {
-		return nil, err
+		return 0, nil, err
+	}
+	// create empty message container, whole frame is already read so the stream is still in sync
+	if typeNum > math.MaxUint32 {
+		return reqID, nil, fmt.Errorf("%w: %d", ErrUnknownMessageType, typeNum)
 	}
-	// create empty message container
-	container, err := getMsgContainer(typeNum)
+	container, err := getMsgContainer(uint(typeNum))
 	if err != nil {
-		return nil, err
+		return reqID, nil, fmt.Errorf("%w: %d", ErrUnknownMessageType, typeNum)
 	}
 	// write message protobuf data to empty container
 	err = proto.Unmarshal(data, container)
 	if err != nil {
-		return nil, err
+		return reqID, nil, fmt.Errorf("%w: %v", ErrMalformedMessage, err)
 	}
-	return container, nil
+	return reqID, container, nil
 }
 
-// WriteMsg wrtie a message to stream. Message consist of message type(varint) + data(protobuf data)
-func (ms *MsgStream) WriteMsg(msg proto.Message) error {
-	typeNum, err := getMsgNum(msg)
+// WriteMsg wrtie a message tagged with request id to stream. Message consist of message type(varint) + request id(varint) + data(protobuf data)
+// It is safe to call WriteMsg from multiple goroutines.
+func (ms *MsgStream) WriteMsg(reqID uint64, msg proto.Message) error {
+	return ms.WriteMsgContext(context.Background(), reqID, msg)
+}
+
+// WriteMsgContext is WriteMsg honoring deadline and cancellation of ctx while waiting for other writers and writing.
+func (ms *MsgStream) WriteMsgContext(ctx context.Context, reqID uint64, msg proto.Message) error {
+	frame, err := ms.encodeMsg(reqID, msg)
 	if err != nil {
 		return err
 	}
-	err = ms.writeVarInt(typeNum)
+	return ms.writeFrame(ctx, frame)
+}
+
+// encodeMsg encode message tagged with request id to a frame ready to be written to stream.
+// Message larger than MaxFrameSize is rejected here rather than by the peer.
+func (ms *MsgStream) encodeMsg(reqID uint64, msg proto.Message) ([]byte, error) {
+	typeNum, err := getMsgNum(msg)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	data, err := proto.Marshal(msg)
+	// deterministic so that same message is always written as same bytes, even with map fields
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
 	if err != nil {
+		return nil, err
+	}
+	if len(data) > ms.config.MaxFrameSize {
+		return nil, fmt.Errorf("%w: %d bytes exceeds limit of %d bytes", ErrFrameTooLarge, len(data), ms.config.MaxFrameSize)
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	frame := make([]byte, 0, 3*binary.MaxVarintLen64+len(data))
+	n := binary.PutUvarint(tmp[:], uint64(typeNum))
+	frame = append(frame, tmp[:n]...)
+	n = binary.PutUvarint(tmp[:], reqID)
+	frame = append(frame, tmp[:n]...)
+	n = binary.PutUvarint(tmp[:], uint64(len(data)))
+	frame = append(frame, tmp[:n]...)
+	return append(frame, data...), nil
+}
+
+// writeFrame write encoded frame to stream. If ctx is done before writing starts, ctx.Err() is returned and stream is untouched.
+// If ctx is done in the middle of writing, the frame may be partially written and the stream should not be used anymore.
+func (ms *MsgStream) writeFrame(ctx context.Context, frame []byte) error {
+	ms.wmutex.Lock()
+	defer ms.wmutex.Unlock()
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	err = ms.writeLenDelimData(data)
+	// deadline is set for every write, zero deadline clears the one set by previous writer
+	writeDeadline := deadline(ms.config.WriteTimeout)
+	if d, ok := ctx.Deadline(); ok && (writeDeadline.IsZero() || d.Before(writeDeadline)) {
+		writeDeadline = d
+	}
+	ms.conn.SetWriteDeadline(writeDeadline)
+	if ctx.Done() != nil {
+		// interrupt the write when ctx is cancelled, and make sure it happens before next writer starts
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				ms.conn.SetWriteDeadline(aLongTimeAgo)
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-stopped
+		}()
+	}
+	_, err := ms.out.Write(frame)
 	if err != nil {
 		return err
 	}
-	ms.out.Flush()
-	return err
+	return ms.out.Flush()
 }
 
 // RemoteAddr returns remote address of underlying network connection.
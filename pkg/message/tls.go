@@ -0,0 +1,138 @@
+package message
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	sync "sync"
+	"time"
+)
+
+// TLSConfig configures TLS of connections between client and server. Files are read again when
+// they are modified, so certificates can be renewed without restarting.
+type TLSConfig struct {
+	CertFile string // certificate presented to the peer, required for server and for client of mutual TLS
+	KeyFile  string // private key of CertFile
+	// CAFile contains CA certificates verifying the peer. Client uses system roots if it is empty.
+	// Server requires and verifies client certificates if it is set, which is mutual TLS.
+	CAFile     string
+	ServerName string // name in server certificate checked by client, server host if empty
+}
+
+// Check loads certificate files once, to report misconfiguration at startup.
+func (c TLSConfig) Check() error {
+	return newTLSLoader(c).load()
+}
+
+// tlsLoader provides tls.Config built from TLSConfig files, reloading them when modified.
+// If reloading fails, previously loaded certificates keep being used.
+type tlsLoader struct {
+	config  TLSConfig
+	mutex   sync.Mutex
+	modTime map[string]time.Time // modification time of each file when it was loaded
+	cert    *tls.Certificate     // certificate loaded from CertFile and KeyFile
+	pool    *x509.CertPool       // CA certificates loaded from CAFile
+}
+
+func newTLSLoader(config TLSConfig) *tlsLoader {
+	return &tlsLoader{config: config}
+}
+
+// changed reports whether any file is modified since loaded last time, and returns current modification times.
+func (l *tlsLoader) changed() (bool, map[string]time.Time, error) {
+	modTime := make(map[string]time.Time)
+	changed := l.modTime == nil
+	for _, name := range []string{l.config.CertFile, l.config.KeyFile, l.config.CAFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return false, nil, err
+		}
+		modTime[name] = info.ModTime()
+		if !info.ModTime().Equal(l.modTime[name]) {
+			changed = true
+		}
+	}
+	return changed, modTime, nil
+}
+
+// load reads files again if any of them is modified.
+func (l *tlsLoader) load() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	changed, modTime, err := l.changed()
+	if err != nil || !changed {
+		return err
+	}
+	var cert *tls.Certificate
+	if l.config.CertFile != "" || l.config.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(l.config.CertFile, l.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("cannot load certificate: %v", err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if l.config.CAFile != "" {
+		pem, err := ioutil.ReadFile(l.config.CAFile)
+		if err != nil {
+			return fmt.Errorf("cannot load CA: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", l.config.CAFile)
+		}
+	}
+	l.cert, l.pool, l.modTime = cert, pool, modTime
+	return nil
+}
+
+// serverConfig returns tls.Config for accepting a client connection.
+// Error of reloading files is returned with config of certificates loaded before, if there are.
+func (l *tlsLoader) serverConfig() (*tls.Config, error) {
+	err := l.load()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.cert == nil {
+		if err == nil {
+			err = fmt.Errorf("server certificate is not configured")
+		}
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{*l.cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if l.pool != nil {
+		config.ClientCAs = l.pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, err
+}
+
+// clientConfig returns tls.Config for connecting to server at host.
+// Error of reloading files is returned with config of files loaded before, if there are.
+func (l *tlsLoader) clientConfig(host string) (*tls.Config, error) {
+	err := l.load()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.modTime == nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		RootCAs:    l.pool,
+		ServerName: l.config.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if l.cert != nil {
+		config.Certificates = []tls.Certificate{*l.cert}
+	}
+	return config, err
+}
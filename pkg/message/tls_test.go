@@ -0,0 +1,185 @@
+package message
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM file of the CA certificate
+}
+
+// tempDir creates directory removed when the test finishes.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeCert writes certificate and key of template signed by ca in dir, self-signed if ca is nil.
+func writeCert(t *testing.T, dir, name string, template *x509.Certificate, ca *testCA) (certFile, keyFile string, cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template.SerialNumber = serial
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, parentKey := template, key
+	if ca != nil {
+		parent, parentKey = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile, cert, key
+}
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	file, _, cert, key := writeCert(t, dir, name, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	return &testCA{cert: cert, key: key, file: file}
+}
+
+// issue writes certificate for server at 127.0.0.1 or for client signed by ca.
+func (ca *testCA) issue(t *testing.T, dir, name string, server bool) (certFile, keyFile string) {
+	template := &x509.Certificate{KeyUsage: x509.KeyUsageDigitalSignature}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	certFile, keyFile, _, _ = writeCert(t, dir, name, template, ca)
+	return certFile, keyFile
+}
+
+// startTLSServer starts server requiring client certificate signed by ca.
+func startTLSServer(t *testing.T, dir string, ca *testCA) string {
+	certFile, keyFile := ca.issue(t, dir, "server", true)
+	server := NewServer("127.0.0.1", "0", ServerConfig{
+		TLS: &TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: ca.file},
+	}, nil, nil, zap.NewNop())
+	go server.Run()
+	t.Cleanup(func() { server.listener.Close() })
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	return port
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCA(t, dir, "ca")
+	port := startTLSServer(t, dir, ca)
+	certFile, keyFile := ca.issue(t, dir, "web", false)
+
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 1, MaxCalls: 1,
+		TLS: &TLSConfig{CertFile: certFile, KeyFile: keyFile, CAFile: ca.file}})
+	stream, err := pool.GetMsgStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.destroyMsgStream(stream)
+	if _, err = stream.Call(context.Background(), &HealthcheckMessage{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLSRejected(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCA(t, dir, "ca")
+	port := startTLSServer(t, dir, ca)
+	// client certificate signed by another CA, and no client certificate at all
+	other := newTestCA(t, dir, "other")
+	certFile, keyFile := other.issue(t, dir, "intruder", false)
+	configs := []*TLSConfig{
+		{CertFile: certFile, KeyFile: keyFile, CAFile: ca.file},
+		{CAFile: ca.file},
+	}
+	for _, config := range configs {
+		pool := NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 1, MaxCalls: 1, TLS: config})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		stream, err := pool.GetMsgStream(ctx)
+		cancel()
+		if err == nil {
+			pool.destroyMsgStream(stream)
+			t.Errorf("client with %+v should be rejected", config)
+		}
+	}
+	// client not trusting server's CA refuses to connect
+	pool := NewMsgStreamPool("tcp", "127.0.0.1", port, PoolConfig{MaxConn: 1, MaxCalls: 1, TLS: &TLSConfig{CAFile: other.file}})
+	if _, err := pool.GetMsgStream(context.Background()); err == nil {
+		t.Error("server certificate of unknown CA should be rejected")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCA(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "server", true)
+	loader := newTLSLoader(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	config, err := loader.serverConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := config.Certificates[0].Certificate[0]
+
+	// renewed certificate is picked up once files are modified
+	ca.issue(t, dir, "server", true)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	os.Chtimes(keyFile, future, future)
+	if config, err = loader.serverConfig(); err != nil {
+		t.Fatal(err)
+	}
+	renewed := config.Certificates[0].Certificate[0]
+	if string(renewed) == string(first) {
+		t.Error("certificate has not been reloaded")
+	}
+
+	// broken file does not replace certificate loaded before
+	ioutil.WriteFile(certFile, []byte("broken"), 0600)
+	future = future.Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	config, err = loader.serverConfig()
+	if err == nil {
+		t.Error("broken certificate should be reported")
+	}
+	if config == nil || string(config.Certificates[0].Certificate[0]) != string(renewed) {
+		t.Error("previous certificate should be kept")
+	}
+}
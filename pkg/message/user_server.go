@@ -0,0 +1,406 @@
+package message
+
+import (
+	"context"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"git.garena.com/youngiek.song/entry_task/internal/jwt"
+	"git.garena.com/youngiek.song/entry_task/internal/models"
+	"git.garena.com/youngiek.song/entry_task/internal/notifier"
+	"git.garena.com/youngiek.song/entry_task/internal/session"
+	"go.uber.org/zap"
+)
+
+// userServer implements UserService with user store and session manager issuing access tokens.
+type userServer struct {
+	store         models.UserStore      // users and their passwords
+	sessions      session.Manager       // issues and revokes access tokens
+	tokenIssuer   *jwt.TokenIssuer      // keys of JWT access tokens, published by PublicKeys
+	resetTokens   session.ResetTokens   // password reset tokens not used yet
+	refreshTokens session.RefreshTokens // refresh tokens of logins
+	notifier      notifier.Notifier     // delivers password reset tokens
+	logger        *zap.Logger           // for log
+}
+
+// Login handles login request. Compare password of user with the store's data, and starts session on the device.
+// On success, response with generated access token, refresh token of the session and error code 0. Refresh token
+// is issued only by session manager renewing access tokens, like JWT which can't be extended.
+// On fail, response with empty token and positive error code.
+func (s *userServer) Login(ctx context.Context, req *LoginRequest) (*LoginResponse, error) {
+	id := req.Id
+	password := req.Password
+	valid, err := s.store.Authenticate(ctx, id, password)
+	if err != nil {
+		s.logger.Error("Error authenticating id/password", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrDB
+	}
+	if !valid {
+		s.logger.Warn("invalid Id/password", zap.String("remote", remoteAddr(ctx)), zap.String("id", id))
+		return nil, NewError(ErrorCode_AUTH_FAILED, "Wrong ID/Password")
+	}
+	var device session.Device
+	if req.Device != nil {
+		device = session.Device{UserAgent: req.Device.UserAgent, RemoteIP: req.Device.RemoteIp}
+	}
+	token, sessionID, err := s.sessions.Issue(ctx, id, device)
+	if err != nil {
+		s.logger.Error("Error issuing access token", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	var refreshToken string
+	if _, ok := s.sessions.(session.Renewer); ok {
+		refreshToken, err = s.refreshTokens.Issue(ctx, id, sessionID)
+		if err != nil {
+			s.logger.Error("Error issuing refresh token", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+			return nil, ErrUnknown
+		}
+	}
+	msg := &LoginResponse{
+		Response:     &Response{Code: ErrorCode_OK},
+		Token:        token,
+		RefreshToken: refreshToken,
+	}
+	return msg, nil
+}
+
+// GetUserInfo get information of the user whose token is authenticated by AuthInterceptor from the store.
+// On success, response with user data and error code 0.
+// On fail, response with user data and positive error code.
+func (s *userServer) GetUserInfo(ctx context.Context, req *GetUserInfoRequest) (*GetUserInfoResponse, error) {
+	id, _ := UserIDFromContext(ctx)
+	user, err := s.store.GetUser(ctx, id)
+	if err != nil {
+		s.logger.Error("Error on DB", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrDB
+	}
+	if user == nil {
+		s.logger.Info("No such user", zap.String("remote", remoteAddr(ctx)), zap.String("id", id))
+		return nil, NewError(ErrorCode_NOT_FOUND, "User %s not found", id)
+	}
+	return &GetUserInfoResponse{
+		Response: &Response{Code: ErrorCode_OK},
+		User: &User{
+			Id:       user.Id,
+			Nickname: user.Nickname,
+			PicPath:  user.PicPath,
+		},
+	}, nil
+}
+
+// EditUserInfo edit information of the user authenticated by AuthInterceptor in the store.
+// Id of the user in the request may be empty, other user's id is rejected with ErrDenied.
+// On success, response with error code 0.
+// On fail, response with positive error code.
+func (s *userServer) EditUserInfo(ctx context.Context, req *EditUserInfoRequest) (*Response, error) {
+	if req.User == nil {
+		s.logger.Warn("No user in request", zap.String("remote", remoteAddr(ctx)))
+		return nil, NewError(ErrorCode_INVALID_INPUT, "User is required")
+	}
+	id, _ := UserIDFromContext(ctx)
+	if req.User.Id != "" && req.User.Id != id {
+		s.logger.Warn("Editing other user", zap.String("remote", remoteAddr(ctx)), zap.String("id", id), zap.String("target", req.User.Id))
+		return nil, NewError(ErrorCode_PERMISSION_DENIED, "Cannot edit other user")
+	}
+	err := s.store.UpdateUser(ctx, &models.User{
+		Id:       id,
+		Nickname: req.User.Nickname,
+		PicPath:  req.User.PicPath,
+	})
+	if err != nil {
+		s.logger.Error("Error on DB", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrDB
+	}
+	s.logger.Debug("Edited user", zap.String("remote", remoteAddr(ctx)), zap.String("id", id), zap.String("body", req.User.String()))
+	return &Response{Code: ErrorCode_OK}, nil
+}
+
+// Authenticate check client's priviliege by JWT token, which is already done by AuthInterceptor.
+// On success, response with error code 0.
+// On fail, response with positive error code.
+func (s *userServer) Authenticate(ctx context.Context, req *AuthRequest) (*Response, error) {
+	return &Response{Code: ErrorCode_OK}, nil
+}
+
+// Limits of new user's fields. Passwords longer than 72 bytes are truncated by bcrypt.
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 72
+	maxNicknameLen = 64
+)
+
+// validID matches ids of 4 to 32 letters, digits and underscores.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_]{4,32}$`)
+
+// validatePassword checks password of user id against password policy.
+func validatePassword(id, password string) error {
+	if len(password) < minPasswordLen || len(password) > maxPasswordLen {
+		return NewError(ErrorCode_INVALID_INPUT, "Password must be %d to %d characters", minPasswordLen, maxPasswordLen).WithDetail("field", "password")
+	}
+	var letter, digit bool
+	for _, c := range password {
+		letter = letter || unicode.IsLetter(c)
+		digit = digit || unicode.IsDigit(c)
+	}
+	if !letter || !digit {
+		return NewError(ErrorCode_INVALID_INPUT, "Password must contain both letters and digits").WithDetail("field", "password")
+	}
+	if password == id {
+		return NewError(ErrorCode_INVALID_INPUT, "Password must differ from ID").WithDetail("field", "password")
+	}
+	return nil
+}
+
+// validateNewUser checks fields of new user, error with the invalid field in details is returned.
+func validateNewUser(req *CreateUserRequest) error {
+	if !validID.MatchString(req.Id) {
+		return NewError(ErrorCode_INVALID_INPUT, "ID must be 4 to 32 letters, digits or underscores").WithDetail("field", "id")
+	}
+	if err := validatePassword(req.Id, req.Password); err != nil {
+		return err
+	}
+	if utf8.RuneCountInString(req.Nickname) > maxNicknameLen {
+		return NewError(ErrorCode_INVALID_INPUT, "Nickname must be at most %d characters", maxNicknameLen).WithDetail("field", "nickname")
+	}
+	return nil
+}
+
+// CreateUser registers new user after validating it's fields. Nickname is the id if not given.
+// On success, response with error code 0.
+// On fail, response with positive error code.
+func (s *userServer) CreateUser(ctx context.Context, req *CreateUserRequest) (*Response, error) {
+	if err := validateNewUser(req); err != nil {
+		s.logger.Info("Invalid new user", zap.String("remote", remoteAddr(ctx)), zap.String("id", req.Id), zap.String("error", err.Error()))
+		return nil, err
+	}
+	nickname := req.Nickname
+	if nickname == "" {
+		nickname = req.Id
+	}
+	err := s.store.CreateUser(ctx, &models.User{
+		Id:       req.Id,
+		Password: req.Password,
+		Nickname: nickname,
+		PicPath:  req.Id + ".jpg",
+	})
+	if err == models.ErrUserExists {
+		return nil, NewError(ErrorCode_ALREADY_EXISTS, "ID %s is already taken", req.Id).WithDetail("field", "id")
+	}
+	if err != nil {
+		s.logger.Error("Error on DB", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrDB
+	}
+	return &Response{Code: ErrorCode_OK}, nil
+}
+
+// setPassword replaces password of user id and revokes all of the user's sessions and password reset tokens.
+func (s *userServer) setPassword(ctx context.Context, id, password string) error {
+	if err := validatePassword(id, password); err != nil {
+		return err
+	}
+	err := s.store.UpdateUser(ctx, &models.User{Id: id, Password: password})
+	if err != nil {
+		s.logger.Error("Error on DB", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return ErrDB
+	}
+	if err = s.resetTokens.RevokeUser(ctx, id); err != nil {
+		s.logger.Error("Error revoking reset tokens", zap.String("remote", remoteAddr(ctx)), zap.String("id", id), zap.String("error", err.Error()))
+	}
+	if err = s.refreshTokens.RevokeUser(ctx, id); err == nil {
+		err = s.sessions.RevokeUser(ctx, id)
+	}
+	if err != nil {
+		s.logger.Error("Error revoking sessions", zap.String("remote", remoteAddr(ctx)), zap.String("id", id), zap.String("error", err.Error()))
+		return NewError(ErrorCode_UNKNOWN, "Password is changed, but other sessions could not be logged out")
+	}
+	return nil
+}
+
+// ChangePassword replaces password of the user authenticated by AuthInterceptor, after checking the current password.
+// On success, response with error code 0 and the access token of the request is no longer valid.
+// On fail, response with positive error code.
+func (s *userServer) ChangePassword(ctx context.Context, req *ChangePasswordRequest) (*Response, error) {
+	id, _ := UserIDFromContext(ctx)
+	valid, err := s.store.Authenticate(ctx, id, req.CurrentPassword)
+	if err != nil {
+		s.logger.Error("Error authenticating id/password", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrDB
+	}
+	if !valid {
+		s.logger.Warn("Wrong current password", zap.String("remote", remoteAddr(ctx)), zap.String("id", id))
+		return nil, NewError(ErrorCode_AUTH_FAILED, "Wrong current password")
+	}
+	if err = s.setPassword(ctx, id, req.NewPassword); err != nil {
+		return nil, err
+	}
+	return &Response{Code: ErrorCode_OK}, nil
+}
+
+// RequestPasswordReset issues reset token of the user and sends it by notifier.
+// It succeeds for unknown users too, so that the response does not tell which users exist.
+func (s *userServer) RequestPasswordReset(ctx context.Context, req *PasswordResetRequest) (*Response, error) {
+	user, err := s.store.GetUser(ctx, req.Id)
+	if err != nil {
+		s.logger.Error("Error on DB", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrDB
+	}
+	if user == nil {
+		s.logger.Info("Password reset of unknown user", zap.String("remote", remoteAddr(ctx)), zap.String("id", req.Id))
+		return &Response{Code: ErrorCode_OK}, nil
+	}
+	token, expires, err := s.resetTokens.Issue(ctx, user.Id)
+	if err != nil {
+		s.logger.Error("Error issuing reset token", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	if err = s.notifier.NotifyPasswordReset(ctx, user.Id, token, expires); err != nil {
+		s.logger.Error("Error sending reset token", zap.String("remote", remoteAddr(ctx)), zap.String("id", user.Id), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	return &Response{Code: ErrorCode_OK}, nil
+}
+
+// ResetPassword replaces password of the user of reset token. The token can be used only once, even if it fails.
+// On success, response with error code 0.
+// On fail, response with positive error code.
+func (s *userServer) ResetPassword(ctx context.Context, req *ResetPasswordRequest) (*Response, error) {
+	id, err := s.resetTokens.Use(ctx, req.ResetToken)
+	if err == session.ErrResetTokenInvalid {
+		s.logger.Warn("Invalid reset token", zap.String("remote", remoteAddr(ctx)))
+		return nil, NewError(ErrorCode_AUTH_FAILED, "Reset token is invalid or expired")
+	}
+	if err != nil {
+		s.logger.Error("Error using reset token", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	if err := s.setPassword(ctx, id, req.NewPassword); err != nil {
+		return nil, err
+	}
+	return &Response{Code: ErrorCode_OK}, nil
+}
+
+// Logout revokes session of the access token with it's refresh tokens, or all sessions of the user if AllDevices
+// is set.
+// On success, response with error code 0.
+// On fail, response with positive error code.
+func (s *userServer) Logout(ctx context.Context, req *LogoutRequest) (*Response, error) {
+	id, _ := UserIDFromContext(ctx)
+	var err error
+	if req.AllDevices {
+		if err = s.refreshTokens.RevokeUser(ctx, id); err == nil {
+			err = s.sessions.RevokeUser(ctx, id)
+		}
+	} else {
+		sessionID, _ := SessionIDFromContext(ctx)
+		if err = s.refreshTokens.RevokeSession(ctx, id, sessionID); err == nil {
+			err = s.refreshTokens.Revoke(ctx, req.RefreshToken)
+		}
+		if err == nil {
+			err = s.sessions.Revoke(ctx, req.Token)
+		}
+	}
+	if err != nil {
+		s.logger.Error("Error revoking token", zap.String("remote", remoteAddr(ctx)), zap.String("id", id), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	return &Response{Code: ErrorCode_OK}, nil
+}
+
+// RefreshToken rotates refresh token, issuing new access token of it's session and refresh token.
+// If used refresh token is presented after session.RefreshGrace, the session is revoked with every refresh token of
+// it as it may have been stolen.
+// On success, response with the tokens and error code 0.
+// On fail, response with positive error code.
+func (s *userServer) RefreshToken(ctx context.Context, req *RefreshTokenRequest) (*RefreshTokenResponse, error) {
+	renewer, ok := s.sessions.(session.Renewer)
+	if !ok {
+		s.logger.Info("Refresh token of session manager not renewing tokens", zap.String("remote", remoteAddr(ctx)))
+		return nil, NewError(ErrorCode_AUTH_FAILED, "Refresh token is invalid or expired")
+	}
+	id, sessionID, refreshToken, err := s.refreshTokens.Rotate(ctx, req.RefreshToken)
+	if err == session.ErrRefreshReused {
+		s.logger.Warn("Refresh token reused, revoking it's session", zap.String("remote", remoteAddr(ctx)), zap.String("id", id))
+		if err = s.sessions.RevokeSession(ctx, id, sessionID); err != nil {
+			s.logger.Error("Error revoking session", zap.String("remote", remoteAddr(ctx)), zap.String("id", id), zap.String("error", err.Error()))
+		}
+		return nil, NewError(ErrorCode_AUTH_FAILED, "Session is revoked, login again")
+	}
+	if err == session.ErrRefreshInvalid {
+		s.logger.Info("Invalid refresh token", zap.String("remote", remoteAddr(ctx)))
+		return nil, NewError(ErrorCode_AUTH_FAILED, "Refresh token is invalid or expired")
+	}
+	if err != nil {
+		s.logger.Error("Error issuing refresh token", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	token, err := renewer.Renew(ctx, id, sessionID)
+	if err != nil {
+		s.logger.Error("Error issuing access token", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	return &RefreshTokenResponse{
+		Response:     &Response{Code: ErrorCode_OK},
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// PublicKeys returns public keys of the token issuer as JSON Web Key Set.
+// On success, response with the key set and error code 0.
+// On fail, response with positive error code.
+func (s *userServer) PublicKeys(ctx context.Context, req *PublicKeysRequest) (*PublicKeysResponse, error) {
+	jwks, err := s.tokenIssuer.PublicKeys()
+	if err != nil {
+		s.logger.Error("Error getting public keys", zap.String("remote", remoteAddr(ctx)), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	return &PublicKeysResponse{
+		Response: &Response{Code: ErrorCode_OK},
+		Jwks:     string(jwks),
+	}, nil
+}
+
+// ListSessions returns sessions of the user authenticated by AuthInterceptor, marking the session of the request.
+// On success, response with the sessions and error code 0.
+// On fail, response with positive error code.
+func (s *userServer) ListSessions(ctx context.Context, req *ListSessionsRequest) (*ListSessionsResponse, error) {
+	id, _ := UserIDFromContext(ctx)
+	current, _ := SessionIDFromContext(ctx)
+	sessions, err := s.sessions.List(ctx, id)
+	if err != nil {
+		s.logger.Error("Error listing sessions", zap.String("remote", remoteAddr(ctx)), zap.String("id", id), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	msg := &ListSessionsResponse{Response: &Response{Code: ErrorCode_OK}}
+	for _, session := range sessions {
+		msg.Sessions = append(msg.Sessions, &Session{
+			Id:       session.ID,
+			Device:   &Device{UserAgent: session.UserAgent, RemoteIp: session.RemoteIP},
+			Created:  session.Created.Unix(),
+			LastSeen: session.LastSeen.Unix(),
+			Current:  session.ID == current,
+		})
+	}
+	return msg, nil
+}
+
+// RevokeSession logs the user authenticated by AuthInterceptor out of one of it's sessions, revoking refresh tokens
+// of the session too. Session of other user is ignored, so it succeeds without telling whether the session exists.
+// On success, response with error code 0.
+// On fail, response with positive error code.
+func (s *userServer) RevokeSession(ctx context.Context, req *RevokeSessionRequest) (*Response, error) {
+	id, _ := UserIDFromContext(ctx)
+	if req.SessionId == "" {
+		return nil, NewError(ErrorCode_INVALID_INPUT, "Session ID is required").WithDetail("field", "session_id")
+	}
+	err := s.refreshTokens.RevokeSession(ctx, id, req.SessionId)
+	if err == nil {
+		err = s.sessions.RevokeSession(ctx, id, req.SessionId)
+	}
+	if err != nil {
+		s.logger.Error("Error revoking session", zap.String("remote", remoteAddr(ctx)), zap.String("id", id), zap.String("error", err.Error()))
+		return nil, ErrUnknown
+	}
+	return &Response{Code: ErrorCode_OK}, nil
+}
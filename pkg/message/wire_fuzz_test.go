@@ -0,0 +1,39 @@
+//go:build go1.18
+// +build go1.18
+
+package message
+
+import (
+	"errors"
+	"testing"
+)
+
+// FuzzReadMsg feeds arbitrary bytes to ReadMsg. It must never panic or allocate more than MaxFrameSize
+// for a frame, and whatever it accepts must be encodable again.
+func FuzzReadMsg(f *testing.F) {
+	for _, frame := range encodeGoldenMsgs(f) {
+		f.Add(frame)
+	}
+	f.Add(rawFrame(0, 1, 1<<40, nil))
+	f.Add(rawFrame(1000, 1, 0, nil))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		stream, _ := NewMsgStream(newBufConn(data), StreamConfig{MaxFrameSize: 1 << 16})
+		for {
+			reqID, msg, err := stream.ReadMsg()
+			if err != nil {
+				if msg != nil {
+					t.Fatalf("message %v returned with error %v", msg, err)
+				}
+				if errors.Is(err, ErrUnknownMessageType) || errors.Is(err, ErrMalformedMessage) {
+					// whole frame was consumed, stream is still in sync
+					continue
+				}
+				return
+			}
+			if err = stream.WriteMsg(reqID, msg); err != nil {
+				t.Fatalf("accepted message %v cannot be written: %v", msg, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,257 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// bufConn is a net.Conn reading from fixed bytes and writing to a buffer, deadlines are ignored.
+type bufConn struct {
+	net.Conn
+	in  *bytes.Reader
+	out bytes.Buffer
+}
+
+func newBufConn(data []byte) *bufConn {
+	return &bufConn{in: bytes.NewReader(data)}
+}
+
+func (c *bufConn) Read(b []byte) (int, error)       { return c.in.Read(b) }
+func (c *bufConn) Write(b []byte) (int, error)      { return c.out.Write(b) }
+func (c *bufConn) Close() error                     { return nil }
+func (c *bufConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *bufConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *bufConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+
+// registeredTypes returns every message type in the registry ordered by type number.
+func registeredTypes() []protoreflect.MessageType {
+	nums := make([]int, 0, len(registry.types))
+	for num := range registry.types {
+		nums = append(nums, int(num))
+	}
+	sort.Ints(nums)
+	types := make([]protoreflect.MessageType, len(nums))
+	for i, num := range nums {
+		types[i] = registry.types[uint(num)]
+	}
+	return types
+}
+
+// randomMsg fills every field of a new message of type mt with random values.
+func randomMsg(r *rand.Rand, mt protoreflect.MessageType) proto.Message {
+	msg := mt.New()
+	fillRandom(r, msg, 0)
+	return msg.Interface()
+}
+
+func fillRandom(r *rand.Rand, msg protoreflect.Message, depth int) {
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		// leave some fields unset, absent and zero fields must round-trip as well
+		if r.Intn(4) == 0 {
+			continue
+		}
+		switch {
+		case fd.IsMap():
+			m := msg.Mutable(fd).Map()
+			for n := r.Intn(4); n > 0; n-- {
+				m.Set(randomValue(r, fd.MapKey()).MapKey(), randomValue(r, fd.MapValue()))
+			}
+		case fd.IsList():
+			list := msg.Mutable(fd).List()
+			for n := r.Intn(4); n > 0; n-- {
+				if fd.Kind() == protoreflect.MessageKind {
+					elem := list.NewElement()
+					fillRandom(r, elem.Message(), depth+1)
+					list.Append(elem)
+				} else {
+					list.Append(randomValue(r, fd))
+				}
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			if depth < 3 {
+				fillRandom(r, msg.Mutable(fd).Message(), depth+1)
+			}
+		default:
+			msg.Set(fd, randomValue(r, fd))
+		}
+	}
+}
+
+// randomValue returns random value of scalar field fd.
+func randomValue(r *rand.Rand, fd protoreflect.FieldDescriptor) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(randomString(r))
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(randomString(r)))
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(r.Intn(2) == 0)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(r.Uint32())
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return protoreflect.ValueOfUint64(r.Uint64())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(r.Uint32()))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(int64(r.Uint64()))
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		return protoreflect.ValueOfEnum(values.Get(r.Intn(values.Len())).Number())
+	default:
+		panic(fmt.Sprintf("randomValue: field kind %v of %s is not supported, add it to randomValue", fd.Kind(), fd.FullName()))
+	}
+}
+
+func randomString(r *rand.Rand) string {
+	var sb strings.Builder
+	for n := r.Intn(64); n > 0; n-- {
+		sb.WriteRune(rune(r.Intn(0x3000)))
+	}
+	return sb.String()
+}
+
+// Every registered message written by WriteMsg is read back by ReadMsg as an equal message,
+// and writing the read message again gives exactly the same bytes.
+func TestWireRoundTripProperty(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, mt := range registeredTypes() {
+		for i := 0; i < 200; i++ {
+			msg := randomMsg(r, mt)
+			reqID := r.Uint64()
+			conn := newBufConn(nil)
+			stream, _ := NewMsgStream(conn, StreamConfig{})
+			if err := stream.WriteMsg(reqID, msg); err != nil {
+				t.Fatalf("write %v: %v", msg, err)
+			}
+			frame := append([]byte(nil), conn.out.Bytes()...)
+
+			conn = newBufConn(frame)
+			stream, _ = NewMsgStream(conn, StreamConfig{})
+			gotID, got, err := stream.ReadMsg()
+			if err != nil {
+				t.Fatalf("read %v: %v", msg, err)
+			}
+			if gotID != reqID || !proto.Equal(got, msg) {
+				t.Fatalf("got %d %v, want %d %v", gotID, got, reqID, msg)
+			}
+			if err = stream.WriteMsg(gotID, got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(conn.out.Bytes(), frame) {
+				t.Fatalf("%s: frame changed after round trip\n got %x\nwant %x", mt.Descriptor().FullName(), conn.out.Bytes(), frame)
+			}
+		}
+	}
+}
+
+// goldenMsgs are sample messages whose encoded frames are kept in testdata/frames.golden.
+// Every registered message must have a sample, so that adding or renumbering message shows up in the golden file.
+var goldenMsgs = []proto.Message{
+	&HealthcheckMessage{},
+	&LoginRequest{Id: "user1", Password: "pass1"},
+	&GetUserInfoRequest{Token: "token"},
+	&EditUserInfoRequest{Token: "token", User: &User{Id: "user1", Nickname: "nick", PicPath: "/pic/user1.png"}},
+	&AuthRequest{Token: "token"},
+	&Response{Code: ErrorCode_INVALID_INPUT, Message: "Wrong input", Details: map[string]string{"field": "nickname", "reason": "too long"}},
+	&LoginResponse{Response: &Response{}, Token: "token"},
+	&GetUserInfoResponse{Response: &Response{}, User: &User{Id: "user1", Nickname: "nick", PicPath: "/pic/user1.png"}},
+	&ProtocolError{Code: ProtocolError_FRAME_TOO_LARGE, Reason: "too large"},
+	&Hello{Version: 1, Features: []string{FeatureMultiplexing}, Build: "test"},
+	&GoAway{Reason: "server shutting down"},
+	&CreateUserRequest{Id: "user2", Password: "passw0rd", Nickname: "nick"},
+	&ChangePasswordRequest{Token: "token", CurrentPassword: "passw0rd", NewPassword: "newpassw0rd"},
+	&PasswordResetRequest{Id: "user1"},
+	&ResetPasswordRequest{ResetToken: "reset", NewPassword: "newpassw0rd"},
+	&LogoutRequest{Token: "token", AllDevices: true},
+	&RefreshTokenRequest{RefreshToken: "refresh"},
+	&RefreshTokenResponse{Response: &Response{}, Token: "token", RefreshToken: "refresh"},
+	&PublicKeysRequest{},
+	&PublicKeysResponse{Response: &Response{}, Jwks: `{"keys":[]}`},
+	&ListSessionsRequest{Token: "token"},
+	&ListSessionsResponse{Response: &Response{}, Sessions: []*Session{{Id: "sid", Device: &Device{UserAgent: "ua", RemoteIp: "127.0.0.1"}, Created: 1, LastSeen: 2, Current: true}}},
+	&RevokeSessionRequest{Token: "token", SessionId: "sid"},
+}
+
+// encodeGoldenMsgs encodes each of goldenMsgs with request id of it's position starting from 1.
+func encodeGoldenMsgs(tb testing.TB) [][]byte {
+	frames := make([][]byte, len(goldenMsgs))
+	for i, msg := range goldenMsgs {
+		conn := newBufConn(nil)
+		stream, _ := NewMsgStream(conn, StreamConfig{})
+		if err := stream.WriteMsg(uint64(i+1), msg); err != nil {
+			tb.Fatal(err)
+		}
+		frames[i] = conn.out.Bytes()
+	}
+	return frames
+}
+
+// goldenFrames formats frames of goldenMsgs, one line per message with it's name and hex of the frame.
+func goldenFrames(tb testing.TB) []byte {
+	var buf bytes.Buffer
+	for i, frame := range encodeGoldenMsgs(tb) {
+		fmt.Fprintf(&buf, "%s %s\n", goldenMsgs[i].ProtoReflect().Descriptor().FullName(), hex.EncodeToString(frame))
+	}
+	return buf.Bytes()
+}
+
+// Run go test -run TestWireGolden -update to accept an intended protocol change.
+func TestWireGolden(t *testing.T) {
+	covered := make(map[protoreflect.FullName]bool)
+	for _, msg := range goldenMsgs {
+		covered[msg.ProtoReflect().Descriptor().FullName()] = true
+	}
+	for _, mt := range registeredTypes() {
+		if name := mt.Descriptor().FullName(); !covered[name] {
+			t.Errorf("message %s has no golden sample, add it to goldenMsgs", name)
+		}
+	}
+
+	path := filepath.Join("testdata", "frames.golden")
+	got := goldenFrames(t)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encoded frames differ from %s, run with -update if the change is intended\n got:\n%s\nwant:\n%s", path, got, want)
+	}
+
+	// golden frames must also be read back as the sample messages
+	scanner := bufio.NewScanner(bytes.NewReader(want))
+	for i := 0; scanner.Scan() && i < len(goldenMsgs); i++ {
+		fields := strings.Fields(scanner.Text())
+		frame, err := hex.DecodeString(fields[len(fields)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, _ := NewMsgStream(newBufConn(frame), StreamConfig{})
+		reqID, msg, err := stream.ReadMsg()
+		if err != nil || reqID != uint64(i+1) || !proto.Equal(msg, goldenMsgs[i]) {
+			t.Errorf("%s: got %d %v %v", fields[0], reqID, msg, err)
+		}
+	}
+}